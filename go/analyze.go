@@ -0,0 +1,295 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+)
+
+// MoveAnalysis is the verdict on one move of an analyzed game. Scores are
+// in centipawns from the point of view of the side that moved, mates are
+// clamped to analysisClamp.
+type MoveAnalysis struct {
+	Color    Color
+	Number   int
+	SAN      string
+	Best     int
+	Played   int
+	Loss     int
+	Accuracy float64
+	// Label is "inaccuracy", "mistake", "blunder" or empty.
+	Label string
+	// BestLine is the engine's line in SAN, starting with the best move,
+	// when it differs from the move played.
+	BestLine []string
+}
+
+type GameAnalysis struct {
+	Game     *Game
+	Tags     map[string]string
+	Moves    []MoveAnalysis
+	ACPL     map[Color]float64
+	Accuracy map[Color]float64
+}
+
+// Thresholds are the centipawn losses from which a move is labeled.
+type Thresholds struct {
+	Inaccuracy int
+	Mistake    int
+	Blunder    int
+}
+
+const analysisClamp = 1000
+
+// AnalyzeGame searches every position of the game and compares the move
+// played with the engine's choice.
+func AnalyzeGame(pg *PGNGame, e *Engine, th Thresholds) (*GameAnalysis, error) {
+	g, err := pg.Replay()
+	if err != nil {
+		return nil, err
+	}
+	played := append([]*Move{}, g.Moves...)
+	for i := len(played) - 1; i >= 0; i-- {
+		g.undoMove(played[i])
+	}
+
+	a := &GameAnalysis{Game: g, Tags: pg.Tags, ACPL: map[Color]float64{}, Accuracy: map[Color]float64{}}
+	results := make([]SearchResult, len(played)+1)
+	for i := 0; i <= len(played); i++ {
+		results[i] = analysisSearch(g, e)
+		if i < len(played) {
+			g.doMove(played[i])
+		}
+	}
+	for i := len(played) - 1; i >= 0; i-- {
+		g.undoMove(played[i])
+	}
+
+	counts := map[Color]int{}
+	for i := 0; i < len(played); i++ {
+		m := played[i]
+		ma := MoveAnalysis{Color: g.OnTurn, Number: g.FullmoveNumber, SAN: g.SAN(m)}
+		ma.Best = clampScore(results[i].Score)
+		ma.Played = -clampScore(results[i+1].Score)
+		if results[i].Move != nil && results[i].Move.UCI() == m.UCI() {
+			ma.Played = ma.Best
+		}
+		ma.Loss = max(0, ma.Best-ma.Played)
+		ma.Accuracy = moveAccuracy(ma.Best, ma.Played)
+		switch {
+		case ma.Loss >= th.Blunder:
+			ma.Label = "blunder"
+		case ma.Loss >= th.Mistake:
+			ma.Label = "mistake"
+		case ma.Loss >= th.Inaccuracy:
+			ma.Label = "inaccuracy"
+		}
+		if ma.Label != "" {
+			ma.BestLine = sanLine(g, results[i].PV)
+		}
+		a.ACPL[ma.Color] += float64(ma.Loss)
+		a.Accuracy[ma.Color] += ma.Accuracy
+		counts[ma.Color]++
+		a.Moves = append(a.Moves, ma)
+		g.doMove(m)
+	}
+	for _, c := range []Color{White, Black} {
+		if counts[c] > 0 {
+			a.ACPL[c] /= float64(counts[c])
+			a.Accuracy[c] /= float64(counts[c])
+		}
+	}
+	if pg.Result != Ongoing && g.Result() == Ongoing {
+		g.Adjudicate(pg.Result, pg.Tags["Termination"])
+	}
+	return a, nil
+}
+
+func analysisSearch(g *Game, e *Engine) SearchResult {
+	if result, _ := g.variantOutcome(); result != Ongoing {
+		return SearchResult{Score: outcomeScore(result, g.OnTurn, 0)}
+	}
+	if len(g.possibleMoves()) == 0 {
+		if g.Board.inCheck(g.OnTurn) {
+			return SearchResult{Score: -MateScore}
+		}
+		return SearchResult{}
+	}
+	return e.Search(g)
+}
+
+func clampScore(score int) int {
+	return max(-analysisClamp, min(analysisClamp, score))
+}
+
+// winPercent maps centipawns to the expected score in percent, the curve
+// lichess fits to its games.
+func winPercent(cp int) float64 {
+	return 50 + 50*(2/(1+math.Exp(-0.00368208*float64(cp)))-1)
+}
+
+func moveAccuracy(best int, played int) float64 {
+	drop := winPercent(best) - winPercent(played)
+	accuracy := 103.1668*math.Exp(-0.04354*drop) - 3.1669
+	return math.Max(0, math.Min(100, accuracy))
+}
+
+// sanLine converts a line of moves from the current position into SAN.
+func sanLine(g *Game, line []*Move) []string {
+	sans := []string{}
+	done := []*Move{}
+	for i := 0; i < len(line); i++ {
+		uci := line[i].UCI()
+		var m *Move
+		moves := g.possibleMoves()
+		for j := 0; j < len(moves); j++ {
+			if moves[j].UCI() == uci {
+				m = moves[j]
+			}
+		}
+		if m == nil {
+			break
+		}
+		sans = append(sans, g.SAN(m))
+		g.doMove(m)
+		done = append(done, m)
+	}
+	for i := len(done) - 1; i >= 0; i-- {
+		g.undoMove(done[i])
+	}
+	return sans
+}
+
+var analysisNAGs = map[string]string{
+	"inaccuracy": "$6",
+	"mistake":    "$2",
+	"blunder":    "$4",
+}
+
+// PGN writes the game with NAGs on the labeled moves, the engine's line as
+// a variation and the evaluation after every move as an [%eval] comment.
+func (a *GameAnalysis) PGN() string {
+	tokens := []string{}
+	for i := 0; i < len(a.Moves); i++ {
+		ma := a.Moves[i]
+		if ma.Color == White {
+			tokens = append(tokens, fmt.Sprintf("%d.", ma.Number))
+		} else if i == 0 || a.Moves[i-1].Label != "" {
+			tokens = append(tokens, fmt.Sprintf("%d...", ma.Number))
+		}
+		tokens = append(tokens, ma.SAN)
+		if nag := analysisNAGs[ma.Label]; nag != "" {
+			tokens = append(tokens, nag)
+		}
+		eval := ma.Played * int(ma.Color)
+		comment := fmt.Sprintf("[%%eval %.2f]", float64(eval)/100)
+		if ma.Label != "" && len(ma.BestLine) > 0 {
+			label := strings.ToUpper(ma.Label[:1]) + ma.Label[1:]
+			comment += fmt.Sprintf(" %s. %s was best.", label, ma.BestLine[0])
+		}
+		tokens = append(tokens, "{"+comment+"}")
+		if ma.Label != "" && len(ma.BestLine) > 0 {
+			tokens = append(tokens, "("+variationText(ma.Number, ma.Color, ma.BestLine)+")")
+		}
+	}
+
+	tags := map[string]string{}
+	for k, v := range a.Tags {
+		if k != "FEN" && k != "SetUp" && k != "Result" {
+			tags[k] = v
+		}
+	}
+	tags["Annotator"] = "chess analyze"
+	return a.Game.pgnWithMovetext(tags, strings.Join(tokens, " "))
+}
+
+func variationText(number int, color Color, sans []string) string {
+	tokens := []string{}
+	if color == Black {
+		tokens = append(tokens, fmt.Sprintf("%d...", number))
+	}
+	for i := 0; i < len(sans); i++ {
+		if color == White {
+			tokens = append(tokens, fmt.Sprintf("%d.", number))
+		} else {
+			number++
+		}
+		tokens = append(tokens, sans[i])
+		color = -color
+	}
+	return strings.Join(tokens, " ")
+}
+
+func (a *GameAnalysis) PrintSummary() {
+	fmt.Printf("%s - %s\n", a.Tags["White"], a.Tags["Black"])
+	for i := 0; i < len(a.Moves); i++ {
+		ma := a.Moves[i]
+		if ma.Label == "" {
+			continue
+		}
+		dots := "."
+		if ma.Color == Black {
+			dots = "..."
+		}
+		fmt.Printf("  %d%s%s %s (-%d), best %s\n", ma.Number, dots, ma.SAN, ma.Label, ma.Loss, strings.Join(ma.BestLine, " "))
+	}
+	for _, c := range []Color{White, Black} {
+		counts := map[string]int{}
+		for i := 0; i < len(a.Moves); i++ {
+			if a.Moves[i].Color == c {
+				counts[a.Moves[i].Label]++
+			}
+		}
+		fmt.Printf("  %s: acpl %.0f, accuracy %.1f%%, %d inaccuracies, %d mistakes, %d blunders\n",
+			colorName(c), a.ACPL[c], a.Accuracy[c], counts["inaccuracy"], counts["mistake"], counts["blunder"])
+	}
+}
+
+func analyzeCommand(args []string) {
+	flags := flag.NewFlagSet("analyze", flag.ExitOnError)
+	depth := flags.Int("depth", 3, "search depth per position")
+	movetime := flags.Duration("movetime", 0, "search time per position, overrides the depth")
+	inaccuracy := flags.Int("inaccuracy", 50, "centipawn loss of an inaccuracy")
+	mistake := flags.Int("mistake", 100, "centipawn loss of a mistake")
+	blunder := flags.Int("blunder", 200, "centipawn loss of a blunder")
+	out := flags.String("out", "", "file for the annotated PGN, standard output by default")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: chess analyze [flags] game.pgn")
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	games, err := ParsePGN(f)
+	f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		w, err = os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer w.Close()
+	}
+	e := &Engine{Depth: *depth, MoveTime: *movetime}
+	th := Thresholds{Inaccuracy: *inaccuracy, Mistake: *mistake, Blunder: *blunder}
+	for i := 0; i < len(games); i++ {
+		a, err := AnalyzeGame(games[i], e, th)
+		if err != nil {
+			log.Fatalf("game %d: %v", i+1, err)
+		}
+		if *out != "" {
+			a.PrintSummary()
+		}
+		fmt.Fprintln(w, a.PGN())
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+// antichess is won by losing every piece or being stalemated. Captures are
+// compulsory and the king is an ordinary piece.
+type antichess struct{}
+
+func (antichess) Outcome(g *Game) (Result, string) {
+	b := g.Board
+	if len(b.possibleMoves(g.OnTurn)) > 0 {
+		return Ongoing, ""
+	}
+	pieces := b.getPieces()
+	for i := 0; i < len(pieces); i++ {
+		if pieces[i].Color() == g.OnTurn {
+			return winner(g.OnTurn), "stalemate"
+		}
+	}
+	return winner(g.OnTurn), "all pieces lost"
+}
+
+// Insufficient holds when each side has just a bishop and they run on
+// squares of different colors, so that neither can ever be taken.
+func (antichess) Insufficient(g *Game) bool {
+	pieces := g.Board.getPieces()
+	if len(pieces) != 2 || pieces[0].Color() == pieces[1].Color() {
+		return false
+	}
+	for i := 0; i < len(pieces); i++ {
+		if _, ok := pieces[i].(*Bishop); !ok {
+			return false
+		}
+	}
+	a, b := pieces[0].Square(), pieces[1].Square()
+	return (a.x+a.y)%2 != (b.x+b.y)%2
+}
+
+// keepCaptures drops the quiet moves when there is a capture to make.
+func keepCaptures(moves []*Move) []*Move {
+	captures := []*Move{}
+	for i := 0; i < len(moves); i++ {
+		if moves[i].CapturedPiece != nil {
+			captures = append(captures, moves[i])
+		}
+	}
+	if len(captures) == 0 {
+		return moves
+	}
+	return captures
+}
+
+// keepCaptures is keepCaptures for a generated list.
+func (l *MoveList) keepCaptures() {
+	n := 0
+	for i := 0; i < l.n; i++ {
+		if l.moves[i].Flags&FlagCapture != 0 {
+			l.moves[n] = l.moves[i]
+			n++
+		}
+	}
+	if n > 0 {
+		l.n = n
+	}
+}
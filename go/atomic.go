@@ -0,0 +1,57 @@
+package main
+
+// explode carries out an atomic capture: the capturer and every piece but a
+// pawn next to the target square leave the board. Both kings may go.
+func (b *Board) explode(m *Move) {
+	if m.CapturedPiece == nil || !b.Rules().Atomic {
+		return
+	}
+	m.Exploded = m.Exploded[:0]
+	b.Grid[m.End.x][m.End.y] = nil
+	for dx := int8(-1); dx <= 1; dx++ {
+		for dy := int8(-1); dy <= 1; dy++ {
+			sq := &Square{x: m.End.x + dx, y: m.End.y + dy}
+			if dx == 0 && dy == 0 || !b.Contains(sq) {
+				continue
+			}
+			p := b.GetPiece(sq)
+			if p == nil {
+				continue
+			}
+			if _, ok := p.(*Pawn); ok {
+				continue
+			}
+			m.Exploded = append(m.Exploded, p)
+			b.Grid[sq.x][sq.y] = nil
+		}
+	}
+}
+
+// unexplode puts back what explode blew up, except the capturer which
+// undoMove takes care of.
+func (b *Board) unexplode(m *Move) {
+	if m.CapturedPiece == nil || !b.Rules().Atomic {
+		return
+	}
+	for i := 0; i < len(m.Exploded); i++ {
+		sq := m.Exploded[i].Square()
+		b.Grid[sq.x][sq.y] = m.Exploded[i]
+	}
+}
+
+// nextToKing tells whether the king of the color stands on a square around
+// s.
+func (b *Board) nextToKing(s Square, color Color) bool {
+	for dx := int8(-1); dx <= 1; dx++ {
+		for dy := int8(-1); dy <= 1; dy++ {
+			sq := Square{x: s.x + dx, y: s.y + dy}
+			if dx == 0 && dy == 0 || !b.Contains(&sq) {
+				continue
+			}
+			if k, ok := b.Grid[sq.x][sq.y].(*King); ok && k.color == color {
+				return true
+			}
+		}
+	}
+	return false
+}
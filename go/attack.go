@@ -0,0 +1,254 @@
+package main
+
+var allDirections = []DirectionName{Up, Down, Left, Right, UpLeft, UpRight, DownLeft, DownRight}
+
+var knightVectors = []Vector{
+	Vector{x: 1, y: 2},
+	Vector{x: -1, y: 2},
+	Vector{x: 1, y: -2},
+	Vector{x: -1, y: -2},
+	Vector{x: 2, y: 1},
+	Vector{x: -2, y: 1},
+	Vector{x: 2, y: -1},
+	Vector{x: -2, y: -1},
+}
+
+// Pin is a piece that cannot leave the line between its king and the
+// pinner. Ray holds the squares it may still move to: those between the king
+// and the pinner, and the pinner's square.
+type Pin struct {
+	Piece  Piece
+	Pinner Piece
+	Ray    []*Square
+}
+
+// slides tells whether the piece attacks along the direction, any distance.
+// Fairy pieces are asked through their definition instead.
+func slides(p Piece, d DirectionName) bool {
+	switch p.(type) {
+	case *Queen:
+		return true
+	case *Rook:
+		return d <= Right
+	case *Bishop:
+		return d >= UpLeft
+	}
+	return false
+}
+
+// Attackers returns the pieces of the color that attack the square, kings
+// included. Whatever stands on the square does not matter, so the result
+// also tells who defends a piece.
+func (b *Board) Attackers(s *Square, color Color) []Piece {
+	attackers := []Piece{}
+	b.eachAttacker(*s, color, func(from Square) {
+		attackers = append(attackers, b.Grid[from.x][from.y])
+	})
+	return append(attackers, b.fairyAttackers(s, color)...)
+}
+
+// eachAttacker calls fn with the square of every piece of the color that
+// attacks s, fairy pieces left out. It works on values, so that the move
+// generator can use it without allocating.
+func (b *Board) eachAttacker(s Square, color Color, fn func(from Square)) {
+	for i := 0; i < len(knightVectors); i++ {
+		t := Square{x: s.x + knightVectors[i].x, y: s.y + knightVectors[i].y}
+		if !b.Contains(&t) {
+			continue
+		}
+		if n, ok := b.Grid[t.x][t.y].(*Knight); ok && n.color == color {
+			fn(t)
+		}
+	}
+	for d := DirectionName(0); d < DirectionName(len(DirVectors[0])); d++ {
+		v := DirVectors[0][d]
+		t := s
+		for j := 0; ; j++ {
+			t.x += v.x
+			t.y += v.y
+			if !b.Contains(&t) {
+				break
+			}
+			p := b.Grid[t.x][t.y]
+			if p == nil {
+				continue
+			}
+			if _, fairy := p.(*FairyPiece); fairy {
+				break
+			}
+			if p.Color() == color {
+				attacks := slides(p, d)
+				if j == 0 {
+					switch p.(type) {
+					case *King:
+						attacks = true
+					case *Pawn:
+						// a pawn attacks diagonally forward, so it stands
+						// one row behind the square from its point of view
+						attacks = v.x != 0 && v.y == -int8(color)
+					}
+				}
+				if attacks {
+					fn(t)
+				}
+			}
+			break
+		}
+	}
+}
+
+// Attacks returns the squares the piece attacks, including those occupied
+// by pieces of its own color.
+func Attacks(p Piece) []*Square {
+	squares := []*Square{}
+	s := p.Square()
+	b := p.Board()
+	switch p.(type) {
+	case *Pawn:
+		for _, dx := range []int8{-1, 1} {
+			sq := &Square{x: s.x + dx, y: s.y + int8(p.Color())}
+			if b.Contains(sq) {
+				squares = append(squares, sq)
+			}
+		}
+	case *Knight:
+		for i := 0; i < len(knightVectors); i++ {
+			if sq := s.AddVector(&knightVectors[i]); b.Contains(sq) {
+				squares = append(squares, sq)
+			}
+		}
+	case *King:
+		for i := 0; i < len(allDirections); i++ {
+			v := DirVectors[colorIndex(White)][allDirections[i]]
+			if sq := s.AddVector(&v); b.Contains(sq) {
+				squares = append(squares, sq)
+			}
+		}
+	case *FairyPiece:
+		def := p.(*FairyPiece).Def
+		for x := int8(0); x < b.Files(); x++ {
+			for y := int8(0); y < b.Ranks(); y++ {
+				if def.attacks(b, *s, p.Color(), Square{x: x, y: y}) {
+					squares = append(squares, &Square{x: x, y: y})
+				}
+			}
+		}
+	default:
+		for i := 0; i < len(allDirections); i++ {
+			d := allDirections[i]
+			if !slides(p, d) {
+				continue
+			}
+			ray := s.Direction(b, White, d)
+			for j := 0; j < len(ray); j++ {
+				squares = append(squares, ray[j])
+				if b.GetPiece(ray[j]) != nil {
+					break
+				}
+			}
+		}
+	}
+	return squares
+}
+
+// AttackMap counts for every square how many pieces of the color attack it.
+func (b *Board) AttackMap(color Color) [MaxSize][MaxSize]int {
+	var m [MaxSize][MaxSize]int
+	pieces := b.getPieces()
+	for i := 0; i < len(pieces); i++ {
+		if pieces[i].Color() != color {
+			continue
+		}
+		squares := Attacks(pieces[i])
+		for j := 0; j < len(squares); j++ {
+			m[squares[j].x][squares[j].y]++
+		}
+	}
+	return m
+}
+
+// Checkers returns the pieces giving check to the king of the color.
+func (b *Board) Checkers(color Color) []Piece {
+	king := b.getKing(color)
+	if king == nil || b.Rules().Antichess {
+		return []Piece{}
+	}
+	return b.Attackers(king.Square(), -color)
+}
+
+// Pins returns the pieces of the color pinned to their own king.
+func (b *Board) Pins(color Color) []Pin {
+	pins := []Pin{}
+	king := b.getKing(color)
+	if king == nil || b.Rules().Antichess {
+		return pins
+	}
+	k := *king.Square()
+	b.eachPin(k, color, func(pinned Square, pinner Square, d DirectionName) {
+		// the ray runs from next to the king up to the pinner
+		n := max(abs(int(pinner.x-k.x)), abs(int(pinner.y-k.y)))
+		ray := king.Square().Direction(b, White, d)[:n:n]
+		pins = append(pins, Pin{Piece: b.Grid[pinned.x][pinned.y], Pinner: b.Grid[pinner.x][pinner.y], Ray: ray})
+	})
+	return pins
+}
+
+// eachPin calls fn for every piece of the color pinned to the king on the
+// square, with its pinner and the direction from the king to both.
+func (b *Board) eachPin(king Square, color Color, fn func(pinned Square, pinner Square, d DirectionName)) {
+	for d := DirectionName(0); d < DirectionName(len(DirVectors[0])); d++ {
+		v := DirVectors[0][d]
+		t := king
+		var own Square
+		found := false
+		for {
+			t.x += v.x
+			t.y += v.y
+			if !b.Contains(&t) {
+				break
+			}
+			p := b.Grid[t.x][t.y]
+			if p == nil {
+				continue
+			}
+			if !found {
+				if p.Color() != color {
+					break
+				}
+				own = t
+				found = true
+				continue
+			}
+			if p.Color() != color && slides(p, d) {
+				fn(own, t, d)
+			}
+			break
+		}
+	}
+}
+
+// XRayAttackers returns the sliders of the color that attack the square
+// through exactly one piece of either color.
+func (b *Board) XRayAttackers(s *Square, color Color) []Piece {
+	attackers := []Piece{}
+	for i := 0; i < len(allDirections); i++ {
+		d := allDirections[i]
+		squares := s.Direction(b, White, d)
+		blocked := false
+		for j := 0; j < len(squares); j++ {
+			p := b.GetPiece(squares[j])
+			if p == nil {
+				continue
+			}
+			if !blocked {
+				blocked = true
+				continue
+			}
+			if p.Color() == color && slides(p, d) {
+				attackers = append(attackers, p)
+			}
+			break
+		}
+	}
+	return attackers
+}
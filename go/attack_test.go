@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// pieceSquares names the squares of the pieces, sorted.
+func pieceSquares(pieces []Piece) string {
+	names := []string{}
+	for i := 0; i < len(pieces); i++ {
+		names = append(names, pieces[i].Square().String())
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+func TestAttackMap(t *testing.T) {
+	m := InitBoard().AttackMap(White)
+	ranks := [][8]int{
+		{0, 1, 1, 1, 1, 1, 1, 0},
+		{1, 1, 1, 4, 4, 1, 1, 1},
+		{2, 2, 3, 2, 2, 3, 2, 2},
+		{0, 0, 0, 0, 0, 0, 0, 0},
+	}
+	for y := 0; y < len(ranks); y++ {
+		for x := 0; x < 8; x++ {
+			if m[x][y] != ranks[y][x] {
+				t.Errorf("%s: %d attackers, want %d", &Square{x: int8(x), y: int8(y)}, m[x][y], ranks[y][x])
+			}
+		}
+	}
+}
+
+func TestPins(t *testing.T) {
+	// the knight and the bishop are pinned, the pawn only stands before a
+	// knight and the rook on a1 looks through two pieces
+	g, err := ParseFEN("4k3/4r3/8/8/1b5n/4B1P1/3N4/rBN1K3 w - - 0 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pins := g.Board.Pins(White)
+	got := []string{}
+	for i := 0; i < len(pins); i++ {
+		ray := []string{}
+		for j := 0; j < len(pins[i].Ray); j++ {
+			ray = append(ray, pins[i].Ray[j].String())
+		}
+		got = append(got, pins[i].Piece.Square().String()+" by "+pins[i].Pinner.Square().String()+": "+strings.Join(ray, " "))
+	}
+	sort.Strings(got)
+	want := []string{
+		"d2 by b4: d2 c3 b4",
+		"e3 by e7: e2 e3 e4 e5 e6 e7",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("pins:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if pins := g.Board.Pins(Black); len(pins) != 0 {
+		t.Errorf("black pins: %v", pins)
+	}
+}
+
+func TestXRayAttackers(t *testing.T) {
+	// the queen and the e2 rook attack e8 outright, the e1 rook and the
+	// bishop through one piece each and the a8 rook through two
+	g, err := ParseFEN("RNn1k2Q/3p4/8/8/B7/8/4R3/4RK2 b - - 0 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e8, _ := ParseSquare("e8")
+	if got := pieceSquares(g.Board.XRayAttackers(e8, White)); got != "a4 e1" {
+		t.Errorf("x-ray attackers of e8: %s, want a4 e1", got)
+	}
+	if got := pieceSquares(g.Board.Attackers(e8, White)); got != "e2 h8" {
+		t.Errorf("attackers of e8: %s, want e2 h8", got)
+	}
+	if got := pieceSquares(g.Board.Checkers(Black)); got != "e2 h8" {
+		t.Errorf("checkers: %s, want e2 h8", got)
+	}
+}
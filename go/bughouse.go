@@ -0,0 +1,286 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BughouseGame links two crazyhouse games played by two teams. Team 0 has
+// White on board A and Black on board B, team 1 the other colors, so that
+// the partner of a player sits on the other board with the other color. A
+// captured piece goes to the partner's pocket, and when one game ends the
+// other ends with it.
+type BughouseGame struct {
+	Boards [2]*Game
+	// Initial is each team's starting time, zero when untimed.
+	Initial time.Duration
+	// Increment is added to the team's time for every move either partner
+	// makes.
+	Increment time.Duration
+	Moves     []BughouseMove
+
+	// each team's time is shared by the partners and runs while either of
+	// them is on move
+	timed     bool
+	remaining [2]time.Duration
+	since     time.Time
+	started   bool
+	// decider is the board where the game ended
+	decider int
+}
+
+// BughouseMove is a move in the order the two boards saw them.
+type BughouseMove struct {
+	Board int
+	Move  *Move
+	SAN   string
+	// Number is the move's fullmove number on its board.
+	Number int
+}
+
+// NewBughouseGame sets up both boards. A zero initial time plays without
+// clocks.
+func NewBughouseGame(initial time.Duration, increment time.Duration) *BughouseGame {
+	return &BughouseGame{
+		Boards:    [2]*Game{NewGame(Bughouse), NewGame(Bughouse)},
+		Initial:   initial,
+		Increment: increment,
+		timed:     initial > 0,
+		remaining: [2]time.Duration{initial, initial},
+	}
+}
+
+// bughouseTeam is the team playing the color on the board.
+func bughouseTeam(board int, color Color) int {
+	return colorIndex(color) ^ board
+}
+
+// onMove counts the boards on which the team is to move.
+func (bh *BughouseGame) onMove(team int) int {
+	n := 0
+	for i := 0; i < len(bh.Boards); i++ {
+		if bughouseTeam(i, bh.Boards[i].OnTurn) == team {
+			n++
+		}
+	}
+	return n
+}
+
+// Start sets the clocks running; the first move starts them otherwise.
+func (bh *BughouseGame) Start() {
+	if !bh.started {
+		bh.started = true
+		bh.since = time.Now()
+	}
+}
+
+// Remaining returns the time the team has left.
+func (bh *BughouseGame) Remaining(team int) time.Duration {
+	left := bh.remaining[team]
+	if bh.started && bh.onMove(team) > 0 {
+		left -= time.Since(bh.since)
+	}
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// tick charges the time since the last tick to the teams on move.
+func (bh *BughouseGame) tick() {
+	now := time.Now()
+	for team := 0; team < 2; team++ {
+		if bh.onMove(team) > 0 {
+			bh.remaining[team] -= now.Sub(bh.since)
+		}
+	}
+	bh.since = now
+}
+
+// Flagged returns the team that ran out of time or -1.
+func (bh *BughouseGame) Flagged() int {
+	if !bh.timed || !bh.started || bh.Result() != Ongoing {
+		return -1
+	}
+	for team := 0; team < 2; team++ {
+		if bh.Remaining(team) == 0 {
+			return team
+		}
+	}
+	return -1
+}
+
+// Result is the result of board A, which is the result for the team of
+// White on A; board B always ends the other way round.
+func (bh *BughouseGame) Result() Result {
+	return bh.Boards[0].Result()
+}
+
+// Outcome returns the result together with the reason the game ended on
+// the board where it did.
+func (bh *BughouseGame) Outcome() (Result, string) {
+	result, _ := bh.Boards[0].Outcome()
+	_, reason := bh.Boards[bh.decider].Outcome()
+	return result, reason
+}
+
+// Play makes the move, in UCI or SAN, on the board for the side on turn
+// there and hands a captured piece to the partner.
+func (bh *BughouseGame) Play(board int, s string) (*Move, error) {
+	g := bh.Boards[board]
+	if bh.Result() != Ongoing {
+		return nil, fmt.Errorf("game is over")
+	}
+	m, err := g.ParseMove(s)
+	if err != nil {
+		return nil, err
+	}
+	bh.Start()
+	team := bughouseTeam(board, g.OnTurn)
+	if bh.timed {
+		bh.tick()
+		if bh.remaining[team] <= 0 {
+			bh.remaining[team] = 0
+			bh.flagFall(team)
+			return nil, fmt.Errorf("flag fell")
+		}
+		bh.remaining[team] += bh.Increment
+	}
+	bh.Moves = append(bh.Moves, BughouseMove{Board: board, Move: m, SAN: g.SAN(m), Number: g.FullmoveNumber})
+	mover := g.OnTurn
+	g.doMove(m)
+	if m.CapturedPiece != nil {
+		bh.Boards[1-board].Board.Pocket(-mover).add(pocketLetter(m.CapturedPiece))
+	}
+	if result, reason := g.Outcome(); result != Ongoing {
+		bh.end(board, result, reason)
+	}
+	return m, nil
+}
+
+// end settles the other board the same way for the teams.
+func (bh *BughouseGame) end(board int, result Result, reason string) {
+	bh.decider = board
+	other := bh.Boards[1-board]
+	switch result {
+	case WhiteWins:
+		other.Adjudicate(BlackWins, reason+" on the partner board")
+	case BlackWins:
+		other.Adjudicate(WhiteWins, reason+" on the partner board")
+	default:
+		other.Adjudicate(Draw, reason+" on the partner board")
+	}
+}
+
+// flagFall loses both games for the team out of time.
+func (bh *BughouseGame) flagFall(team int) {
+	bh.decider = 0
+	for board := 0; board < len(bh.Boards); board++ {
+		if bughouseTeam(board, White) == team {
+			bh.Boards[board].Adjudicate(BlackWins, "timeout")
+		} else {
+			bh.Boards[board].Adjudicate(WhiteWins, "timeout")
+		}
+	}
+}
+
+// boardNames label the boards in BPGN, uppercase for White's moves.
+var boardNames = [2]byte{'A', 'B'}
+
+// BPGN exports the game in bughouse PGN: the four players in tags and the
+// moves of both boards in the order they were played, numbered like 1A. for
+// White on board A and 1b. for Black on board B. Extra tags override the
+// defaults.
+func (bh *BughouseGame) BPGN(tags map[string]string) string {
+	result, reason := bh.Outcome()
+	roster := [][2]string{
+		{"Event", "?"},
+		{"Site", "?"},
+		{"Date", time.Now().Format("2006.01.02")},
+		{"WhiteA", "?"},
+		{"BlackA", "?"},
+		{"WhiteB", "?"},
+		{"BlackB", "?"},
+		{"TimeControl", "-"},
+		{"Result", string(result)},
+	}
+	if bh.timed {
+		roster[7][1] = fmt.Sprintf("%g+%g", bh.Initial.Seconds(), bh.Increment.Seconds())
+	}
+	var sb strings.Builder
+	for i := 0; i < len(roster); i++ {
+		value := roster[i][1]
+		if v, ok := tags[roster[i][0]]; ok {
+			value = v
+		}
+		fmt.Fprintf(&sb, "[%s \"%s\"]\n", roster[i][0], escapeTag(value))
+	}
+	keys := []string{}
+	for k := range tags {
+		isRoster := false
+		for i := 0; i < len(roster); i++ {
+			if roster[i][0] == k {
+				isRoster = true
+			}
+		}
+		if !isRoster {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for i := 0; i < len(keys); i++ {
+		fmt.Fprintf(&sb, "[%s \"%s\"]\n", keys[i], escapeTag(tags[keys[i]]))
+	}
+	sb.WriteString("\n")
+
+	tokens := []string{}
+	for i := 0; i < len(bh.Moves); i++ {
+		m := bh.Moves[i]
+		name := boardNames[m.Board]
+		if m.Move.Piece.Color() == Black {
+			name |= 0x20
+		}
+		tokens = append(tokens, fmt.Sprintf("%d%c.", m.Number, name), m.SAN)
+	}
+	if reason != "" {
+		tokens = append(tokens, "{"+reason+"}")
+	}
+	tokens = append(tokens, string(result))
+	sb.WriteString(wrapText(strings.Join(tokens, " "), 79))
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// bughouseCommand plays a bughouse game between four random or engine
+// players and prints it in BPGN.
+func bughouseCommand(args []string) {
+	flags := flag.NewFlagSet("bughouse", flag.ExitOnError)
+	depth := flags.Int("depth", 0, "engine depth for the players, 0 plays random moves")
+	initial := flags.Duration("time", 5*time.Minute, "time per team, 0 for none")
+	increment := flags.Duration("increment", 0, "increment per move")
+	flags.Parse(args)
+
+	rand.Seed(time.Now().UnixNano())
+	bh := NewBughouseGame(*initial, *increment)
+	bh.Start()
+	for bh.Result() == Ongoing {
+		// the boards do not wait for each other
+		board := rand.Intn(len(bh.Boards))
+		g := bh.Boards[board]
+		var m *Move
+		if *depth > 0 {
+			m = (&Engine{Depth: *depth}).Search(g).Move
+		} else {
+			m = randomMove(g)
+		}
+		if _, err := bh.Play(board, m.UCI()); err != nil {
+			log.Print(err)
+		}
+	}
+	fmt.Print(bh.BPGN(map[string]string{"Event": "Bughouse"}))
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"math/rand"
+	"os"
+	"strings"
 	"time"
 )
 
@@ -14,29 +18,82 @@ const White Color = 1
 const Black Color = -1
 
 type Game struct {
-	Board  *Board
-	OnTurn Color
+	Board          *Board
+	OnTurn         Color
+	Moves          []*Move
+	HalfmoveClock  int
+	FullmoveNumber int
+	StartFEN       string
+	// ChecksGiven counts the checks of each color in three-check.
+	ChecksGiven [2]int
+
+	adjudication       Result
+	adjudicationReason string
+}
+
+func InitGame() *Game {
+	return &Game{
+		Board:          InitBoard(),
+		OnTurn:         White,
+		FullmoveNumber: 1,
+	}
 }
 
+// possibleMoves returns no moves once the variant decided the game.
 func (g *Game) possibleMoves() []*Move {
+	if result, _ := g.variantOutcome(); result != Ongoing {
+		return []*Move{}
+	}
 	return g.Board.possibleMoves(g.OnTurn)
 }
 
 func (g *Game) doMove(move *Move) {
+	move.HalfmoveClockRemoved = g.HalfmoveClock
+	if _, ok := move.Piece.(*Pawn); ok || move.CapturedPiece != nil {
+		g.HalfmoveClock = 0
+	} else {
+		g.HalfmoveClock++
+	}
+	if g.OnTurn == Black {
+		g.FullmoveNumber++
+	}
 	g.Board.doMove(move)
 	g.OnTurn = -g.OnTurn
+	g.countCheck(false)
+	g.Moves = append(g.Moves, move)
 }
 
 func (g *Game) undoMove(move *Move) {
+	g.countCheck(true)
 	g.Board.undoMove(move)
 	g.OnTurn = -g.OnTurn
+	if g.OnTurn == Black {
+		g.FullmoveNumber--
+	}
+	g.HalfmoveClock = move.HalfmoveClockRemoved
+	g.Moves = g.Moves[:len(g.Moves)-1]
 }
 
 func (g *Game) isOver() bool {
 	if len(g.possibleMoves()) == 0 {
 		return true
 	}
+	return g.isInsufficientMaterial()
+}
 
+func (g *Game) isInsufficientMaterial() bool {
+	if v := g.Board.Rules().Variant; v != nil {
+		return v.Insufficient(g)
+	}
+	return g.lacksMatingMaterial()
+}
+
+// lacksMatingMaterial tells whether neither side has the pieces to mate.
+func (g *Game) lacksMatingMaterial() bool {
+	// captured pieces come back with drops
+	if g.Board.Rules().Drops {
+		return false
+	}
 	pieces := g.Board.getPieces()
 	whiteKnights := 0
 	whiteBishops := 0
@@ -53,6 +110,9 @@ func (g *Game) isOver() bool {
 		if _, ok := p.(*Pawn); ok {
 			return false
 		}
+		if _, ok := p.(*FairyPiece); ok {
+			return false
+		}
 		if _, ok := p.(*Knight); ok {
 			if p.Color() == White {
 				whiteKnights++
@@ -81,9 +141,13 @@ func (g *Game) isOver() bool {
 	return true
 }
 
+// isCheckmate tells whether the side to move is mated; a game the variant
+// already decided is not.
 func (g *Game) isCheckmate() bool {
-	king := g.Board.getKing(g.OnTurn)
-	if !king.IsInCheck() {
+	if g.Board.getKing(g.OnTurn) == nil || !g.Board.inCheck(g.OnTurn) {
+		return false
+	}
+	if result, _ := g.variantOutcome(); result != Ongoing {
 		return false
 	}
 	if len(g.possibleMoves()) > 0 {
@@ -92,9 +156,107 @@ func (g *Game) isCheckmate() bool {
 	return true
 }
 
+type Result string
+
+const (
+	Ongoing   Result = "*"
+	WhiteWins Result = "1-0"
+	BlackWins Result = "0-1"
+	Draw      Result = "1/2-1/2"
+)
+
+func (g *Game) Result() Result {
+	result, _ := g.Outcome()
+	return result
+}
+
+// Outcome returns the result together with the reason the game ended.
+func (g *Game) Outcome() (Result, string) {
+	if g.adjudication != "" {
+		return g.adjudication, g.adjudicationReason
+	}
+	if result, reason := g.variantOutcome(); result != Ongoing {
+		return result, reason
+	}
+	if len(g.possibleMoves()) == 0 {
+		if g.Board.inCheck(g.OnTurn) {
+			if g.OnTurn == White {
+				return BlackWins, "checkmate"
+			}
+			return WhiteWins, "checkmate"
+		}
+		return Draw, "stalemate"
+	}
+	if g.isInsufficientMaterial() {
+		return Draw, "insufficient material"
+	}
+	if g.HalfmoveClock >= 100 {
+		return Draw, "fifty-move rule"
+	}
+	if g.repetitions() >= 3 {
+		return Draw, "threefold repetition"
+	}
+	return Ongoing, ""
+}
+
+// Adjudicate ends the game for reasons outside the board, such as a flag
+// fall or resignation.
+func (g *Game) Adjudicate(result Result, reason string) {
+	g.adjudication = result
+	g.adjudicationReason = reason
+}
+
+// canMate tells whether the color has enough material to ever deliver mate.
+func (g *Game) canMate(color Color) bool {
+	if g.Board.Rules().Drops {
+		return true
+	}
+	pieces := g.Board.getPieces()
+	minors := 0
+	for i := 0; i < len(pieces); i++ {
+		p := pieces[i]
+		if p.Color() != color {
+			continue
+		}
+		switch p.(type) {
+		case *Queen, *Rook, *Pawn, *FairyPiece:
+			return true
+		case *Bishop, *Knight:
+			minors++
+		}
+	}
+	return minors >= 2
+}
+
+// repetitions counts how many times the current position occurred since the
+// last irreversible move, the current occurrence included.
+func (g *Game) repetitions() int {
+	key := g.positionKey()
+	count := 1
+	undone := []*Move{}
+	n := g.HalfmoveClock
+	for i := 0; i < n && len(g.Moves) > 0; i++ {
+		m := g.Moves[len(g.Moves)-1]
+		g.undoMove(m)
+		undone = append(undone, m)
+		if i%2 == 1 && g.positionKey() == key {
+			count++
+		}
+	}
+	for i := len(undone) - 1; i >= 0; i-- {
+		g.doMove(undone[i])
+	}
+	return count
+}
+
 type Board struct {
-	Grid            [Size][Size]Piece
+	Grid            [MaxSize][MaxSize]Piece
 	EnpassantSquare *Square
+	// Pockets hold the pieces in hand when the rules allow drops, see
+	// Board.Pocket.
+	Pockets [2]Pocket
+
+	rules *RuleSet
 }
 
 func (b *Board) GetPiece(s *Square) Piece {
@@ -104,9 +266,18 @@ func (b *Board) GetPiece(s *Square) Piece {
 func (b *Board) doMove(m *Move) {
 	m.EnpassantSquareRemoved = b.EnpassantSquare
 	b.EnpassantSquare = m.EnpassantSquareAdded
+	if m.Drop {
+		b.Pocket(m.Piece.Color()).remove(m.Piece.Letter())
+		b.Grid[m.End.x][m.End.y] = m.Piece
+		return
+	}
+	if m.CapturedPiece != nil && b.Rules().Drops && !b.Rules().PartnerPockets {
+		b.Pocket(m.Piece.Color()).add(pocketLetter(m.CapturedPiece))
+	}
 	if m.PromoteTo != nil {
 		b.Grid[m.Start.x][m.Start.y] = nil
 		b.Grid[m.End.x][m.End.y] = m.PromoteTo
+		b.explode(m)
 		return
 	}
 
@@ -117,23 +288,34 @@ func (b *Board) doMove(m *Move) {
 	}
 	b.Grid[m.End.x][m.End.y] = m.Piece
 	if m.ShortCastle {
-		sq := &Square{x: 7, y: m.Start.y}
+		corner, next := b.Files()-1, b.Files()-3
+		sq := &Square{x: corner, y: m.Start.y}
 		rook := b.GetPiece(sq).(*Rook)
-		rook.square.x = 5
-		b.Grid[7][m.Start.y] = nil
-		b.Grid[5][m.Start.y] = rook
+		rook.square = &Square{x: next, y: m.Start.y}
+		b.Grid[corner][m.Start.y] = nil
+		b.Grid[next][m.Start.y] = rook
 	}
 	if m.LongCastle {
 		sq := &Square{x: 0, y: m.Start.y}
 		rook := b.GetPiece(sq).(*Rook)
-		rook.square.x = 3
+		rook.square = &Square{x: 3, y: m.Start.y}
 		b.Grid[0][m.Start.y] = nil
 		b.Grid[3][m.Start.y] = rook
 	}
+	b.explode(m)
 }
 
 func (b *Board) undoMove(m *Move) {
 	b.EnpassantSquare = m.EnpassantSquareRemoved
+	if m.Drop {
+		b.Grid[m.End.x][m.End.y] = nil
+		b.Pocket(m.Piece.Color()).add(m.Piece.Letter())
+		return
+	}
+	b.unexplode(m)
+	if m.CapturedPiece != nil && b.Rules().Drops && !b.Rules().PartnerPockets {
+		b.Pocket(m.Piece.Color()).remove(pocketLetter(m.CapturedPiece))
+	}
 	m.Piece.undoMove(m.Start)
 	b.Grid[m.Start.x][m.Start.y] = m.Piece
 	b.Grid[m.End.x][m.End.y] = nil
@@ -142,16 +324,17 @@ func (b *Board) undoMove(m *Move) {
 		b.Grid[m.CapturedPiece.Square().x][m.CapturedPiece.Square().y] = m.CapturedPiece
 	}
 	if m.ShortCastle {
-		sq := &Square{x: 5, y: m.Start.y}
+		corner, next := b.Files()-1, b.Files()-3
+		sq := &Square{x: next, y: m.Start.y}
 		rook := b.GetPiece(sq).(*Rook)
-		rook.square.x = 7
-		b.Grid[5][m.Start.y] = nil
-		b.Grid[7][m.Start.y] = rook
+		rook.square = &Square{x: corner, y: m.Start.y}
+		b.Grid[next][m.Start.y] = nil
+		b.Grid[corner][m.Start.y] = rook
 	}
 	if m.LongCastle {
 		sq := &Square{x: 3, y: m.Start.y}
 		rook := b.GetPiece(sq).(*Rook)
-		rook.square.x = 0
+		rook.square = &Square{x: 0, y: m.Start.y}
 		b.Grid[3][m.Start.y] = nil
 		b.Grid[0][m.Start.y] = rook
 	}
@@ -167,8 +350,8 @@ func (b *Board) setPieces(pieces []Piece) {
 
 func (b *Board) getPieces() []Piece {
 	pieces := []Piece{}
-	for i := Size - Size; i < Size; i++ {
-		for j := Size - Size; j < Size; j++ {
+	for i := int8(0); i < b.Files(); i++ {
+		for j := int8(0); j < b.Ranks(); j++ {
 			p := b.Grid[i][j]
 			if p != nil {
 				pieces = append(pieces, p)
@@ -178,38 +361,61 @@ func (b *Board) getPieces() []Piece {
 	return pieces
 }
 
+// getKing returns the king of the color, nil when it has none, as after an
+// atomic explosion or in antichess. With several kings it is any of them.
 func (b *Board) getKing(c Color) *King {
 	pieces := b.getPieces()
-	var king *King
 	for i := 0; i < len(pieces); i++ {
-		piece := pieces[i]
-		k, ok := piece.(*King)
-		if !ok {
-			continue
-		}
-		if k.Color() == c {
-			king = k
-			break
+		if k, ok := pieces[i].(*King); ok && k.Color() == c {
+			return k
 		}
 	}
-	if king == nil || king.Color() != c {
-		panic("king not found")
+	return nil
+}
+
+// inCheck tells whether the king of the color is attacked. A king that
+// exploded counts as in check, so that having no moves reads as lost. There
+// is no check in antichess nor for the kingless horde.
+func (b *Board) inCheck(c Color) bool {
+	if b.Rules().Antichess {
+		return false
+	}
+	king := b.getKing(c)
+	if king == nil {
+		return b.Rules().Atomic
 	}
-	return king
+	return king.IsInCheck()
 }
 
 func (b *Board) possibleMoves(color Color) []*Move {
+	return b.generatedMoves(color)
+}
+
+// filteredMoves plays every candidate and keeps those that do not leave the
+// king in check. It is the slow but simple reference for GenerateMoves.
+func (b *Board) filteredMoves(color Color) []*Move {
 	moves := []*Move{}
 	candidates := b.moveCandidates(color)
 	for i := 0; i < len(candidates); i++ {
 		m := candidates[i]
 		b.doMove(m)
-		king := b.getKing(color)
-		if !king.IsInCheck() {
+		legal := !b.inCheck(color)
+		// racing kings forbids giving check as well
+		if legal && b.Rules().NoChecks && b.inCheck(-color) {
+			legal = false
+		}
+		// blowing up the other king wins even from check
+		if !legal && b.Rules().Atomic && b.getKing(color) != nil && b.getKing(-color) == nil {
+			legal = true
+		}
+		if legal {
 			moves = append(moves, m)
 		}
 		b.undoMove(m)
 	}
+	if b.Rules().Antichess {
+		return keepCaptures(moves)
+	}
 	return moves
 }
 
@@ -224,103 +430,135 @@ func (b *Board) moveCandidates(color Color) []*Move {
 		possibleMoves := piece.PossibleMoves()
 		moves = append(moves, possibleMoves...)
 	}
-	return moves
+	return append(moves, b.dropCandidates(color)...)
 }
 
-//TODO: tests
+// IsAttacked tells whether a piece of the color standing on the square
+// would be attacked. In atomic chess nothing next to the other king is,
+// since the capture would blow that king up.
 func (b *Board) IsAttacked(square *Square, color Color) bool {
-	knight := &Knight{PieceBase{color: color, square: square, board: b}}
-	moves := knight.PossibleMoves()
-	for i := 0; i < len(moves); i++ {
-		m := moves[i]
-		if _, ok := m.CapturedPiece.(*Knight); ok {
-			return true
-		}
-	}
-	bishop := &Bishop{StraightGoer{PieceBase{color: color, square: square, board: b}}}
-	moves = bishop.PossibleMoves()
-	for i := 0; i < len(moves); i++ {
-		m := moves[i]
-		if _, ok := m.CapturedPiece.(*Bishop); ok {
-			return true
-		}
-	}
-	rook := &Rook{StraightGoer{PieceBase{color: color, square: square, board: b}}}
-	moves = rook.PossibleMoves()
-	for i := 0; i < len(moves); i++ {
-		m := moves[i]
-		if _, ok := m.CapturedPiece.(*Rook); ok {
-			return true
-		}
-	}
-	queen := &Queen{StraightGoer{PieceBase{color: color, square: square, board: b}}}
-	moves = queen.PossibleMoves()
-	for i := 0; i < len(moves); i++ {
-		m := moves[i]
-		if _, ok := m.CapturedPiece.(*Queen); ok {
-			return true
-		}
-	}
-	pawn := &Pawn{PieceBase{color: color, square: square, board: b}}
-	moves = pawn.PossibleCaptures()
-	for i := 0; i < len(moves); i++ {
-		m := moves[i]
-		if _, ok := m.CapturedPiece.(*Pawn); ok {
-			return true
-		}
+	if b.Rules().Atomic && b.nextToKing(*square, -color) {
+		return false
 	}
-	return false
+	return len(b.Attackers(square, -color)) > 0
 }
 
 func (b *Board) Print() {
-	for i := Size - 1; i >= 0; i-- {
-		fmt.Printf("%c ", int('₁')+int(i))
-		for j := Size - Size; j < Size; j++ {
+	b.Fprint(os.Stdout)
+}
+
+// Fprint prints the board to w.
+func (b *Board) Fprint(w io.Writer) {
+	b.printSeen(w, nil)
+}
+
+// printSeen prints the board with '?' on the squares not seen; nil sees
+// them all.
+func (b *Board) printSeen(w io.Writer, seen *[MaxSize][MaxSize]bool) {
+	for i := b.Ranks() - 1; i >= 0; i-- {
+		fmt.Fprintf(w, "%c ", int('₁')+int(i))
+		for j := int8(0); j < b.Files(); j++ {
 			piece := b.Grid[j][i]
+			if seen != nil && !seen[j][i] {
+				fmt.Fprint(w, "? ")
+				continue
+			}
 			if piece == nil {
 				if (i+j)%2 == 0 {
-					fmt.Print("\033[90m", "· ", "\033[0m")
+					fmt.Fprint(w, "\033[90m", "· ", "\033[0m")
 				} else {
-					fmt.Print("· ")
+					fmt.Fprint(w, "· ")
 				}
 				continue
 			}
-			piece.Print()
-			fmt.Print(" ")
+			fmt.Fprint(w, piece.Symbol()+" ")
 		}
-		fmt.Println()
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, "  "+strings.Join(fileLetters[:b.Files()], " "))
+	if b.Rules().Drops {
+		fmt.Fprintln(w, "  in hand "+b.pocketString())
 	}
-	fmt.Println("  ᵃ ᵇ ᶜ ᵈ ᵉ ᶠ ᵍ ʰ")
 }
 
+var fileLetters = []string{"ᵃ", "ᵇ", "ᶜ", "ᵈ", "ᵉ", "ᶠ", "ᵍ", "ʰ", "ⁱ", "ʲ"}
+
 type Square struct {
 	x int8
 	y int8
 }
 
+// IsValid tells whether the square is on a standard board; see
+// Board.Contains for other sizes.
 func (s *Square) IsValid() bool {
 	return s.x >= 0 && s.y >= 0 && s.x < Size && s.y < Size
 }
 
+// squareMargin pads sharedSquares past the edges by a knight's jump.
+const squareMargin = 2
+
+// sharedSquares holds one Square per coordinate, margin included, so that
+// AddVector and Direction hand out pointers without allocating. Squares are
+// never changed through a pointer, which makes sharing them safe.
+var sharedSquares [MaxSize + 2*squareMargin][MaxSize + 2*squareMargin]Square
+
+// rays lists the shared squares from every square of the largest board in
+// every direction of either color, up to the edge.
+var rays [2][MaxSize][MaxSize][8][]*Square
+
+func init() {
+	for x := int8(0); x < MaxSize+2*squareMargin; x++ {
+		for y := int8(0); y < MaxSize+2*squareMargin; y++ {
+			sharedSquares[x][y] = Square{x: x - squareMargin, y: y - squareMargin}
+		}
+	}
+	for c := 0; c < 2; c++ {
+		for x := int8(0); x < MaxSize; x++ {
+			for y := int8(0); y < MaxSize; y++ {
+				for d := 0; d < len(DirVectors[c]); d++ {
+					v := DirVectors[c][d]
+					ray := []*Square{}
+					for sx, sy := x+v.x, y+v.y; sx >= 0 && sy >= 0 && sx < MaxSize && sy < MaxSize; sx, sy = sx+v.x, sy+v.y {
+						ray = append(ray, squareAt(sx, sy))
+					}
+					rays[c][x][y][d] = ray
+				}
+			}
+		}
+	}
+}
+
+// squareAt returns the shared square, a new one beyond the margin.
+func squareAt(x int8, y int8) *Square {
+	if x < -squareMargin || y < -squareMargin || x >= MaxSize+squareMargin || y >= MaxSize+squareMargin {
+		return &Square{x: x, y: y}
+	}
+	return &sharedSquares[x+squareMargin][y+squareMargin]
+}
+
 func (s *Square) AddVector(v *Vector) *Square {
-	return &Square{x: s.x + v.x, y: s.y + v.y}
+	return squareAt(s.x+v.x, s.y+v.y)
 }
 
-func (s *Square) Direction(color Color, directionName DirectionName) []*Square {
-	v := DirVectors[color][directionName]
-	squares := make([]*Square, 0, 8)
-	sq := s.AddVector(&v)
-	for sq.IsValid() {
-		squares = append(squares, sq)
-		sq = sq.AddVector(&v)
+// Direction returns the squares from s to the edge of the board. The slice
+// is shared and must not be changed.
+func (s *Square) Direction(b *Board, color Color, directionName DirectionName) []*Square {
+	ray := rays[colorIndex(color)][s.x][s.y][directionName]
+	n := 0
+	for n < len(ray) && b.Contains(ray[n]) {
+		n++
 	}
-	return squares
+	return ray[:n:n]
 }
 
 func (s *Square) Print() {
+	fmt.Print(s.String())
+}
+
+func (s *Square) String() string {
 	x := int('a') + int(s.x)
 	y := 1 + s.y
-	fmt.Printf("%c%d", x, y)
+	return fmt.Sprintf("%c%d", x, y)
 }
 
 type Vector struct {
@@ -341,8 +579,10 @@ const (
 	DownRight
 )
 
-var DirVectors map[Color]map[DirectionName]Vector = map[Color]map[DirectionName]Vector{
-	White: map[DirectionName]Vector{
+// DirVectors holds the directions as seen by White at index 0 and by Black
+// at index 1, see colorIndex.
+var DirVectors = [2][8]Vector{
+	{
 		Up:        Vector{x: 0, y: 1},
 		Down:      Vector{x: 0, y: -1},
 		Left:      Vector{x: -1, y: 0},
@@ -352,7 +592,7 @@ var DirVectors map[Color]map[DirectionName]Vector = map[Color]map[DirectionName]
 		DownLeft:  Vector{x: -1, y: -1},
 		DownRight: Vector{x: 1, y: -1},
 	},
-	Black: map[DirectionName]Vector{
+	{
 		Up:        Vector{x: 0, y: -1},
 		Down:      Vector{x: 0, y: 1},
 		Left:      Vector{x: 1, y: 0},
@@ -364,12 +604,22 @@ var DirVectors map[Color]map[DirectionName]Vector = map[Color]map[DirectionName]
 	},
 }
 
+func colorIndex(c Color) int {
+	return int(1-c) / 2
+}
+
 // Rook, Knight, Bishop, Queen, King or Pawn
 type PieceBase struct {
 	color       Color
 	square      *Square
 	board       *Board
 	moveCounter int
+	// promoted pieces go back to the pocket as pawns in crazyhouse
+	promoted bool
+}
+
+func (p *PieceBase) base() *PieceBase {
+	return p
 }
 
 func (p *PieceBase) Color() Color {
@@ -388,7 +638,7 @@ func (p *PieceBase) Row() int8 {
 	if p.color == White {
 		return p.square.y
 	} else {
-		return Size - p.square.y - 1
+		return p.board.Ranks() - p.square.y - 1
 	}
 }
 
@@ -411,7 +661,7 @@ func (sg *StraightGoer) CandidateCaptures(directions []DirectionName) []*Square
 	candidates := []*Square{}
 	for i := 0; i < len(directions); i++ {
 		d := directions[i]
-		squares := sg.square.Direction(sg.Color(), d)
+		squares := sg.square.Direction(sg.board, sg.Color(), d)
 		for j := 0; j < len(squares); j++ {
 			c := squares[j]
 			if sg.board.GetPiece(squares[j]) != nil {
@@ -427,7 +677,7 @@ func (sg *StraightGoer) CandidateNonCaptures(directions []DirectionName) []*Squa
 	candidates := []*Square{}
 	for i := 0; i < len(directions); i++ {
 		d := directions[i]
-		squares := sg.square.Direction(sg.Color(), d)
+		squares := sg.square.Direction(sg.board, sg.Color(), d)
 		for j := 0; j < len(squares); j++ {
 			c := squares[j]
 			if sg.board.GetPiece(squares[j]) != nil {
@@ -441,12 +691,15 @@ func (sg *StraightGoer) CandidateNonCaptures(directions []DirectionName) []*Squa
 
 type Piece interface {
 	PossibleMoves() []*Move
-	Print()
+	// Symbol is the figurine the board is printed with.
+	Symbol() string
+	Letter() byte
 	Board() *Board
 	Color() Color
 	Square() *Square
 	doMove(*Square)
 	undoMove(*Square)
+	base() *PieceBase
 }
 
 func PossibleCaptures(p Piece, candidates []*Square) []*Move {
@@ -489,13 +742,13 @@ func (k *King) PossibleMoves() []*Move {
 	moves := []*Move{}
 	dirs := []DirectionName{Up, Down, Left, Right, UpLeft, UpRight, DownLeft, DownRight}
 	for i := 0; i < len(dirs); i++ {
-		vector := DirVectors[k.color][dirs[i]]
+		vector := DirVectors[colorIndex(k.color)][dirs[i]]
 		end := k.square.AddVector(&vector)
-		if !end.IsValid() {
+		if !k.board.Contains(end) {
 			continue
 		}
 		piece := k.board.GetPiece(end)
-		if piece != nil && piece.Color() == k.color {
+		if piece != nil && (piece.Color() == k.color || k.board.Rules().Atomic) {
 			continue
 		}
 		m := &Move{Piece: k, Start: k.Square(), End: end}
@@ -512,13 +765,16 @@ func (k *King) PossibleMoves() []*Move {
 	return moves
 }
 
+// ShortCastle brings the king next to the corner, the rook on its other
+// side. On a standard board that is g1 and f1.
 func (k *King) ShortCastle() []*Move {
 	moves := []*Move{}
-	if k.moveCounter > 0 {
+	if k.moveCounter > 0 || !k.board.Rules().Castling {
 		return moves
 	}
 	y := k.square.y
-	rookPiece := k.board.GetPiece(&Square{x: 7, y: y})
+	corner := k.board.Files() - 1
+	rookPiece := k.board.GetPiece(&Square{x: corner, y: y})
 	if rookPiece == nil {
 		return moves
 	}
@@ -530,27 +786,28 @@ func (k *King) ShortCastle() []*Move {
 		return moves
 	}
 
-	for x := int8(5); x < 7; x++ {
+	for x := k.square.x + 1; x < corner; x++ {
 		sq := &Square{x: x, y: y}
 		if k.board.GetPiece(sq) != nil {
 			return moves
 		}
 	}
-	for x := int8(4); x < 7; x++ {
+	for x := k.square.x; x < corner; x++ {
 		sq := &Square{x: x, y: y}
 		if k.board.IsAttacked(sq, k.color) {
 			return moves
 		}
 	}
-	end := &Square{x: 6, y: y}
+	end := &Square{x: corner - 1, y: y}
 	m := &Move{Piece: k, Start: k.Square(), End: end, ShortCastle: true}
 	moves = append(moves, m)
 	return moves
 }
 
+// LongCastle brings the king to the c file and the rook to the d file.
 func (k *King) LongCastle() []*Move {
 	moves := []*Move{}
-	if k.moveCounter > 0 {
+	if k.moveCounter > 0 || !k.board.Rules().Castling {
 		return moves
 	}
 	y := k.square.y
@@ -566,13 +823,13 @@ func (k *King) LongCastle() []*Move {
 		return moves
 	}
 
-	for x := int8(1); x < 4; x++ {
+	for x := int8(1); x < k.square.x; x++ {
 		sq := &Square{x: x, y: y}
 		if k.board.GetPiece(sq) != nil {
 			return moves
 		}
 	}
-	for x := int8(2); x < 5; x++ {
+	for x := int8(2); x <= k.square.x; x++ {
 		sq := &Square{x: x, y: y}
 		if k.board.IsAttacked(sq, k.color) {
 			return moves
@@ -584,22 +841,22 @@ func (k *King) LongCastle() []*Move {
 	return moves
 }
 
-func (k *King) Print() {
+func (k *King) Symbol() string {
 	if k.color == White {
-		fmt.Print("♚")
-	} else {
-		fmt.Print("♔")
+		return "♚"
 	}
+	return "♔"
 }
 
-func (k *King) IsInCheck() bool {
-	candidates := k.board.moveCandidates(-k.color)
-	for i := 0; i < len(candidates); i++ {
-		if candidates[i].CapturedPiece == k {
-			return true
-		}
+func (k *King) Letter() byte {
+	if k.color == White {
+		return 'K'
 	}
-	return false
+	return 'k'
+}
+
+func (k *King) IsInCheck() bool {
+	return k.board.IsAttacked(k.square, k.color)
 }
 
 type Knight struct {
@@ -621,7 +878,7 @@ func (n *Knight) PossibleMoves() []*Move {
 	for i := 0; i < len(vectors); i++ {
 		vector := vectors[i]
 		end := n.square.AddVector(&vector)
-		if !end.IsValid() {
+		if !n.board.Contains(end) {
 			continue
 		}
 		piece := n.board.GetPiece(end)
@@ -639,12 +896,18 @@ func (n *Knight) PossibleMoves() []*Move {
 	return moves
 }
 
-func (n *Knight) Print() {
+func (n *Knight) Symbol() string {
 	if n.color == White {
-		fmt.Print("♞")
-	} else {
-		fmt.Print("♘")
+		return "♞"
 	}
+	return "♘"
+}
+
+func (n *Knight) Letter() byte {
+	if n.color == White {
+		return 'N'
+	}
+	return 'n'
 }
 
 type Pawn struct {
@@ -659,9 +922,9 @@ func (p *Pawn) PossibleMoves() []*Move {
 
 func (p *Pawn) PossibleNonCaptures() []*Move {
 	noncaptures := []*Move{}
-	vector := DirVectors[p.color][Up]
+	vector := DirVectors[colorIndex(p.color)][Up]
 	end := p.square.AddVector(&vector)
-	if !end.IsValid() {
+	if !p.board.Contains(end) {
 		return noncaptures
 	}
 	if piece := p.board.GetPiece(end); piece != nil {
@@ -669,7 +932,11 @@ func (p *Pawn) PossibleNonCaptures() []*Move {
 	}
 
 	m := &Move{Piece: p, Start: p.Square(), End: end}
-	if p.Row() == 1 {
+	if p.Row() == p.board.Ranks()-2 {
+		return p.promotions(end, nil)
+	}
+	// pawns on the first rank, as in horde, may double-step too
+	if p.Row() <= 1 && p.board.Rules().DoublePush {
 		noncaptures = append(noncaptures, m)
 		newEnd := end.AddVector(&vector)
 		if piece := p.board.GetPiece(newEnd); piece != nil {
@@ -678,51 +945,43 @@ func (p *Pawn) PossibleNonCaptures() []*Move {
 		m = &Move{Piece: p, Start: p.Square(), End: newEnd, EnpassantSquareAdded: end}
 		return append(noncaptures, m)
 	}
-	if p.Row() == 6 {
-		queen := &Queen{StraightGoer{PieceBase{color: p.color, square: end, board: p.board}}}
-		rook := &Rook{StraightGoer{PieceBase{color: p.color, square: end, board: p.board}}}
-		bishop := &Bishop{StraightGoer{PieceBase{color: p.color, square: end, board: p.board}}}
-		knight := &Knight{PieceBase{color: p.color, square: end, board: p.board}}
-		m1 := &Move{
-			Piece:     p,
-			Start:     p.Square(),
-			End:       end,
-			PromoteTo: queen,
-		}
-		m2 := &Move{
-			Piece:     p,
-			Start:     p.Square(),
-			End:       end,
-			PromoteTo: rook,
-		}
-		m3 := &Move{
-			Piece:     p,
-			Start:     p.Square(),
-			End:       end,
-			PromoteTo: bishop,
-		}
-		m4 := &Move{
-			Piece:     p,
-			Start:     p.Square(),
-			End:       end,
-			PromoteTo: knight,
-		}
-		return append(noncaptures, m1, m2, m3, m4)
-	}
 	return append(noncaptures, m)
 }
 
+// promotions returns a move to the square for every piece the rules allow
+// promoting to.
+func (p *Pawn) promotions(end *Square, captured Piece) []*Move {
+	moves := []*Move{}
+	letters := p.board.Rules().Promotions
+	for i := 0; i < len(letters); i++ {
+		letter := letters[i]
+		if p.color == White {
+			letter &^= 0x20
+		}
+		promoted := newPiece(letter, end, p.board)
+		promoted.base().promoted = true
+		moves = append(moves, &Move{
+			Piece:         p,
+			Start:         p.Square(),
+			End:           end,
+			CapturedPiece: captured,
+			PromoteTo:     promoted,
+		})
+	}
+	return moves
+}
+
 func (p *Pawn) PossibleCaptures() []*Move {
 	captures := []*Move{}
 	dirs := []DirectionName{UpLeft, UpRight}
 	for i := 0; i < len(dirs); i++ {
-		vector := DirVectors[p.color][dirs[i]]
+		vector := DirVectors[colorIndex(p.color)][dirs[i]]
 		end := p.square.AddVector(&vector)
-		if !end.IsValid() {
+		if !p.board.Contains(end) {
 			continue
 		}
 		if p.board.EnpassantSquare != nil && *end == *p.board.EnpassantSquare {
-			vector := DirVectors[p.color][Down]
+			vector := DirVectors[colorIndex(p.color)][Down]
 			capturedPiece := p.board.GetPiece(end.AddVector(&vector))
 			m := &Move{
 				Piece:         p,
@@ -737,40 +996,8 @@ func (p *Pawn) PossibleCaptures() []*Move {
 		if capturedPiece == nil || capturedPiece.Color() == p.color {
 			continue
 		}
-		if p.Row() == 6 {
-			queen := &Queen{StraightGoer{PieceBase{color: p.color, square: end, board: p.board}}}
-			rook := &Rook{StraightGoer{PieceBase{color: p.color, square: end, board: p.board}}}
-			bishop := &Bishop{StraightGoer{PieceBase{color: p.color, square: end, board: p.board}}}
-			knight := &Knight{PieceBase{color: p.color, square: end, board: p.board}}
-			m1 := &Move{
-				Piece:         p,
-				Start:         p.Square(),
-				End:           end,
-				CapturedPiece: capturedPiece,
-				PromoteTo:     queen,
-			}
-			m2 := &Move{
-				Piece:         p,
-				Start:         p.Square(),
-				End:           end,
-				CapturedPiece: capturedPiece,
-				PromoteTo:     rook,
-			}
-			m3 := &Move{
-				Piece:         p,
-				Start:         p.Square(),
-				End:           end,
-				CapturedPiece: capturedPiece,
-				PromoteTo:     bishop,
-			}
-			m4 := &Move{
-				Piece:         p,
-				Start:         p.Square(),
-				End:           end,
-				CapturedPiece: capturedPiece,
-				PromoteTo:     knight,
-			}
-			captures = append(captures, m1, m2, m3, m4)
+		if p.Row() == p.board.Ranks()-2 {
+			captures = append(captures, p.promotions(end, capturedPiece)...)
 		} else {
 			m := &Move{
 				Piece:         p,
@@ -784,12 +1011,18 @@ func (p *Pawn) PossibleCaptures() []*Move {
 	return captures
 }
 
-func (p *Pawn) Print() {
+func (p *Pawn) Symbol() string {
 	if p.color == White {
-		fmt.Print("♟")
-	} else {
-		fmt.Print("♙")
+		return "♟"
 	}
+	return "♙"
+}
+
+func (p *Pawn) Letter() byte {
+	if p.color == White {
+		return 'P'
+	}
+	return 'p'
 }
 
 type Rook struct {
@@ -803,12 +1036,18 @@ func (r *Rook) PossibleMoves() []*Move {
 	return append(captures, noncaptures...)
 }
 
-func (r *Rook) Print() {
+func (r *Rook) Symbol() string {
 	if r.color == White {
-		fmt.Print("♜")
-	} else {
-		fmt.Print("♖")
+		return "♜"
+	}
+	return "♖"
+}
+
+func (r *Rook) Letter() byte {
+	if r.color == White {
+		return 'R'
 	}
+	return 'r'
 }
 
 type Bishop struct {
@@ -824,12 +1063,18 @@ func (b *Bishop) PossibleMoves() []*Move {
 	return moves
 }
 
-func (b *Bishop) Print() {
+func (b *Bishop) Symbol() string {
 	if b.color == White {
-		fmt.Print("♝")
-	} else {
-		fmt.Print("♗")
+		return "♝"
 	}
+	return "♗"
+}
+
+func (b *Bishop) Letter() byte {
+	if b.color == White {
+		return 'B'
+	}
+	return 'b'
 }
 
 type Queen struct {
@@ -845,12 +1090,18 @@ func (q *Queen) PossibleMoves() []*Move {
 	return moves
 }
 
-func (q *Queen) Print() {
+func (q *Queen) Symbol() string {
 	if q.color == White {
-		fmt.Print("♛")
-	} else {
-		fmt.Print("♕")
+		return "♛"
 	}
+	return "♕"
+}
+
+func (q *Queen) Letter() byte {
+	if q.color == White {
+		return 'Q'
+	}
+	return 'q'
 }
 
 type Move struct {
@@ -863,6 +1114,24 @@ type Move struct {
 	ShortCastle            bool
 	EnpassantSquareAdded   *Square
 	EnpassantSquareRemoved *Square
+	HalfmoveClockRemoved   int
+
+	// Drop puts Piece from the pocket on End; Start is End as well.
+	Drop bool
+	// Exploded are the pieces next to End an atomic capture blew up, the
+	// capturer aside.
+	Exploded []Piece
+}
+
+func (m *Move) UCI() string {
+	if m.Drop {
+		return string(m.Piece.Letter()&^0x20) + "@" + m.End.String()
+	}
+	uci := m.Start.String() + m.End.String()
+	if m.PromoteTo != nil {
+		uci += string(m.PromoteTo.Letter() | 0x20)
+	}
+	return uci
 }
 
 func (m *Move) Print() {
@@ -875,7 +1144,13 @@ func (m *Move) Print() {
 		return
 	}
 
-	m.Piece.Print()
+	fmt.Print(m.Piece.Symbol())
+	if m.Drop {
+		fmt.Print("@")
+		m.End.Print()
+		fmt.Println()
+		return
+	}
 	m.Start.Print()
 	if m.CapturedPiece == nil {
 		fmt.Print("-")
@@ -885,59 +1160,101 @@ func (m *Move) Print() {
 	m.End.Print()
 	if m.PromoteTo != nil {
 		fmt.Print(" --> ")
-		m.PromoteTo.Print()
+		fmt.Print(m.PromoteTo.Symbol())
 	}
 	fmt.Println()
 }
 
+// InitBoard sets up the standard starting position.
 func InitBoard() *Board {
-	board := &Board{}
-	pieces := []Piece{
-		&Rook{StraightGoer{PieceBase{color: White, square: &Square{x: 0, y: 0}, board: board}}},
-		&Rook{StraightGoer{PieceBase{color: White, square: &Square{x: 7, y: 0}, board: board}}},
-		&Bishop{StraightGoer{PieceBase{color: White, square: &Square{x: 2, y: 0}, board: board}}},
-		&Bishop{StraightGoer{PieceBase{color: White, square: &Square{x: 5, y: 0}, board: board}}},
-		&Queen{StraightGoer{PieceBase{color: White, square: &Square{x: 3, y: 0}, board: board}}},
-		&Knight{PieceBase{color: White, square: &Square{x: 1, y: 0}, board: board}},
-		&Knight{PieceBase{color: White, square: &Square{x: 6, y: 0}, board: board}},
-		&King{PieceBase{color: White, square: &Square{x: 4, y: 0}, board: board}},
-		&Pawn{PieceBase{color: White, square: &Square{x: 0, y: 1}, board: board}},
-		&Pawn{PieceBase{color: White, square: &Square{x: 1, y: 1}, board: board}},
-		&Pawn{PieceBase{color: White, square: &Square{x: 2, y: 1}, board: board}},
-		&Pawn{PieceBase{color: White, square: &Square{x: 3, y: 1}, board: board}},
-		&Pawn{PieceBase{color: White, square: &Square{x: 4, y: 1}, board: board}},
-		&Pawn{PieceBase{color: White, square: &Square{x: 5, y: 1}, board: board}},
-		&Pawn{PieceBase{color: White, square: &Square{x: 6, y: 1}, board: board}},
-		&Pawn{PieceBase{color: White, square: &Square{x: 7, y: 1}, board: board}},
-
-		&Rook{StraightGoer{PieceBase{color: Black, square: &Square{x: 0, y: 7}, board: board}}},
-		&Rook{StraightGoer{PieceBase{color: Black, square: &Square{x: 7, y: 7}, board: board}}},
-		&Bishop{StraightGoer{PieceBase{color: Black, square: &Square{x: 2, y: 7}, board: board}}},
-		&Bishop{StraightGoer{PieceBase{color: Black, square: &Square{x: 5, y: 7}, board: board}}},
-		&Queen{StraightGoer{PieceBase{color: Black, square: &Square{x: 3, y: 7}, board: board}}},
-		&Knight{PieceBase{color: Black, square: &Square{x: 1, y: 7}, board: board}},
-		&Knight{PieceBase{color: Black, square: &Square{x: 6, y: 7}, board: board}},
-		&King{PieceBase{color: Black, square: &Square{x: 4, y: 7}, board: board}},
-		&Pawn{PieceBase{color: Black, square: &Square{x: 0, y: 6}, board: board}},
-		&Pawn{PieceBase{color: Black, square: &Square{x: 1, y: 6}, board: board}},
-		&Pawn{PieceBase{color: Black, square: &Square{x: 2, y: 6}, board: board}},
-		&Pawn{PieceBase{color: Black, square: &Square{x: 3, y: 6}, board: board}},
-		&Pawn{PieceBase{color: Black, square: &Square{x: 4, y: 6}, board: board}},
-		&Pawn{PieceBase{color: Black, square: &Square{x: 5, y: 6}, board: board}},
-		&Pawn{PieceBase{color: Black, square: &Square{x: 6, y: 6}, board: board}},
-		&Pawn{PieceBase{color: Black, square: &Square{x: 7, y: 6}, board: board}},
-	}
-	board.setPieces(pieces)
-	return board
+	g, err := Presets[0].Game()
+	if err != nil {
+		panic(err)
+	}
+	return g.Board
 }
 
 func main() {
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		switch os.Args[1] {
+		case "serve":
+			serve(os.Args[2:])
+		case "stream-demo":
+			streamDemo(os.Args[2:])
+		case "match":
+			match(os.Args[2:])
+		case "uci":
+			uci(os.Args[2:])
+		case "book":
+			bookCommand(os.Args[2:])
+		case "egtb":
+			endgameCommand(os.Args[2:])
+		case "solve":
+			solveCommand(os.Args[2:])
+		case "analyze":
+			analyzeCommand(os.Args[2:])
+		case "mine":
+			mineCommand(os.Args[2:])
+		case "puzzles":
+			puzzlesCommand(os.Args[2:])
+		case "perft":
+			perftCommand(os.Args[2:])
+		case "bughouse":
+			bughouseCommand(os.Args[2:])
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+			fmt.Fprintln(os.Stderr, "usage: chess [-book book.bin] [serve|stream-demo|match|uci|book|egtb|solve|analyze|mine|puzzles|perft|bughouse]")
+			os.Exit(2)
+		}
+		return
+	}
+	randomGame(os.Args[1:])
+}
+
+func randomGame(args []string) {
+	flags := flag.NewFlagSet("chess", flag.ExitOnError)
+	bookPath := flags.String("book", "", "Polyglot book to play the opening from")
+	variant := flags.String("variant", Standard.Name, "rules to play by")
+	viewName := flags.String("view", "full", "what the players see: full, kriegspiel or fog")
+	odds := flags.String("odds", "", "start from a preset such as \"knight odds\" or \"pawn and move\"")
+	flags.Parse(args)
+	rules, err := RuleSetByName(*variant)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	view, err := ParseView(*viewName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var book *Book
+	if *bookPath != "" {
+		b, err := LoadBook(*bookPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		book = b
+	}
+
 	rand.Seed(time.Now().Unix())
-	board := InitBoard()
-	game := Game{
-		Board:  board,
-		OnTurn: White,
+	game := NewGame(rules)
+	if *odds != "" {
+		preset, err := PresetByName(*odds)
+		if err == nil {
+			game, err = preset.Builder().Rules(rules).Build()
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if view != FullView {
+		hiddenGame(game, view)
+		return
 	}
+	board := game.Board
 	i := 1
 	for {
 		board.Print()
@@ -945,43 +1262,21 @@ func main() {
 		if game.isOver() {
 			break
 		}
-		moves := game.possibleMoves()
-		which := rand.Intn(len(moves))
-
-		for j := 0; j < len(moves); j++ {
-			m := moves[j]
-			game.doMove(m)
-			if game.isCheckmate() {
-				game.undoMove(m)
-				which = j
-				break
+		if book != nil {
+			if move := book.PickMove(game); move != nil {
+				game.doMove(move)
+				if move.Piece.Color() == White {
+					fmt.Printf("%d. ", i)
+				} else {
+					fmt.Printf("%d. ... ", i)
+					i++
+				}
+				move.Print()
+				continue
 			}
-			game.undoMove(m)
-			// if m.CapturedPiece != nil {
-			// 	if board.EnpassantSquare != nil && *board.EnpassantSquare == *m.End {
-			// 		which = j
-			// 		fmt.Println("pick: en passant")
-			// 		break
-			// 	}
-			// }
-			// if m.EnpassantSquareAdded != nil {
-			// 	which = j
-			// 	fmt.Println("pick: pawn by two")
-			// 	break
-			// }
-			// if m.ShortCastle {
-			// 	which = j
-			// 	fmt.Println("pick: short castle")
-			// 	break
-			// }
-			// if m.LongCastle {
-			// 	which = j
-			// 	fmt.Println("pick: long castle")
-			// 	break
-			// }
-		}
-
-		move := moves[which]
+			book = nil
+		}
+		move := randomMove(game)
 		game.doMove(move)
 		if move.Piece.Color() == White {
 			fmt.Printf("%d. ", i)
@@ -993,3 +1288,43 @@ func main() {
 		move.Print()
 	}
 }
+
+// randomMove picks a random legal move, but never misses a mate in one.
+func randomMove(game *Game) *Move {
+	moves := game.possibleMoves()
+	which := rand.Intn(len(moves))
+
+	for j := 0; j < len(moves); j++ {
+		m := moves[j]
+		game.doMove(m)
+		if game.isCheckmate() {
+			game.undoMove(m)
+			which = j
+			break
+		}
+		game.undoMove(m)
+		// if m.CapturedPiece != nil {
+		// 	if board.EnpassantSquare != nil && *board.EnpassantSquare == *m.End {
+		// 		which = j
+		// 		fmt.Println("pick: en passant")
+		// 		break
+		// 	}
+		// }
+		// if m.EnpassantSquareAdded != nil {
+		// 	which = j
+		// 	fmt.Println("pick: pawn by two")
+		// 	break
+		// }
+		// if m.ShortCastle {
+		// 	which = j
+		// 	fmt.Println("pick: short castle")
+		// 	break
+		// }
+		// if m.LongCastle {
+		// 	which = j
+		// 	fmt.Println("pick: long castle")
+		// 	break
+		// }
+	}
+	return moves[which]
+}
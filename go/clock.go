@@ -0,0 +1,69 @@
+package main
+
+import "time"
+
+// Clock is a chess clock with Fischer increment. It starts running for the
+// opponent when the first move is pressed.
+type Clock struct {
+	Increment time.Duration
+
+	remaining map[Color]time.Duration
+	running   Color
+	since     time.Time
+}
+
+func NewClock(initial time.Duration, increment time.Duration) *Clock {
+	return &Clock{
+		Increment: increment,
+		remaining: map[Color]time.Duration{White: initial, Black: initial},
+	}
+}
+
+func (c *Clock) Remaining(color Color) time.Duration {
+	left := c.remaining[color]
+	if c.running == color {
+		left -= time.Since(c.since)
+	}
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// Running returns the color whose clock is ticking or 0 when stopped.
+func (c *Clock) Running() Color {
+	return c.running
+}
+
+// Press is called when color completes a move. It returns false when the
+// color's flag fell before the move was made.
+func (c *Clock) Press(color Color) bool {
+	now := time.Now()
+	if c.running == color {
+		c.remaining[color] -= now.Sub(c.since)
+		if c.remaining[color] <= 0 {
+			c.remaining[color] = 0
+			c.running = 0
+			return false
+		}
+		c.remaining[color] += c.Increment
+	}
+	c.running = -color
+	c.since = now
+	return true
+}
+
+func (c *Clock) Stop() {
+	if c.running != 0 {
+		c.remaining[c.running] = c.Remaining(c.running)
+		c.running = 0
+	}
+}
+
+// Flagged returns the color which has run out of time or 0.
+func (c *Clock) Flagged() Color {
+	if c.running != 0 && c.Remaining(c.running) == 0 {
+		return c.running
+	}
+	return 0
+}
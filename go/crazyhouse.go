@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pocket counts the pieces in hand by lowercase letter, 'a' first.
+type Pocket [26]int8
+
+func (p *Pocket) Count(letter byte) int {
+	return int(p[(letter|0x20)-'a'])
+}
+
+func (p *Pocket) add(letter byte) {
+	p[(letter|0x20)-'a']++
+}
+
+func (p *Pocket) remove(letter byte) {
+	p[(letter|0x20)-'a']--
+}
+
+// pocketOrder is how FEN lists the pocket; fairy pieces follow
+// alphabetically.
+const pocketOrder = "qrbnp"
+
+// letters returns the pocket in FEN order, uppercase for White.
+func (p *Pocket) letters(color Color) string {
+	var sb strings.Builder
+	write := func(letter byte) {
+		if color == White {
+			letter &^= 0x20
+		}
+		for i := 0; i < p.Count(letter); i++ {
+			sb.WriteByte(letter)
+		}
+	}
+	for i := 0; i < len(pocketOrder); i++ {
+		write(pocketOrder[i])
+	}
+	for letter := byte('a'); letter <= 'z'; letter++ {
+		if strings.IndexByte(pocketOrder, letter) < 0 {
+			write(letter)
+		}
+	}
+	return sb.String()
+}
+
+// Pocket returns the pieces the color holds.
+func (b *Board) Pocket(color Color) *Pocket {
+	return &b.Pockets[colorIndex(color)]
+}
+
+// pocketString is the FEN pocket, White's pieces first.
+func (b *Board) pocketString() string {
+	return "[" + b.Pocket(White).letters(White) + b.Pocket(Black).letters(Black) + "]"
+}
+
+// setPockets reads the FEN pocket without its brackets.
+func (b *Board) setPockets(s string) error {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c | 0x20 {
+		case 'k':
+			return fmt.Errorf("fen: king in pocket")
+		case 'q', 'r', 'b', 'n', 'p':
+		default:
+			if _, ok := PieceDefs[c|0x20]; !ok {
+				return fmt.Errorf("fen: unknown piece %q in pocket", c)
+			}
+		}
+		color := White
+		if c >= 'a' {
+			color = Black
+		}
+		b.Pocket(color).add(c)
+	}
+	return nil
+}
+
+// pocketLetter is what a captured piece becomes in hand: a promoted piece
+// goes back to being a pawn.
+func pocketLetter(p Piece) byte {
+	if p.base().promoted {
+		return 'p'
+	}
+	return p.Letter() | 0x20
+}
+
+// pocketValue is the material in the pocket.
+func (p *Pocket) value() int {
+	value := 0
+	for letter := byte('a'); letter <= 'z'; letter++ {
+		n := p.Count(letter)
+		if n == 0 {
+			continue
+		}
+		value += n * PieceValue(newPiece(letter, &Square{}, nil))
+	}
+	return value
+}
+
+// dropMove puts the piece from the pocket on the square, the letter's case
+// telling its color. The piece counts as moved, so a dropped rook cannot
+// castle.
+func (b *Board) dropMove(letter byte, s *Square) *Move {
+	p := newPiece(letter, s, b)
+	p.base().moveCounter = 1
+	return &Move{Piece: p, Start: s, End: s, Drop: true}
+}
+
+// pieceLetter is the letter in the case of the color, uppercase for White.
+func pieceLetter(letter byte, color Color) byte {
+	if color == White {
+		return letter &^ 0x20
+	}
+	return letter | 0x20
+}
+
+// canDrop tells whether a piece may be dropped on the empty square: pawns
+// never go to the first or last rank.
+func (b *Board) canDrop(letter byte, s Square) bool {
+	return letter|0x20 != 'p' || s.y > 0 && s.y < b.Ranks()-1
+}
+
+// dropCandidates returns every drop of the color on an empty square.
+func (b *Board) dropCandidates(color Color) []*Move {
+	moves := []*Move{}
+	if !b.Rules().Drops {
+		return moves
+	}
+	pocket := b.Pocket(color)
+	for letter := byte('a'); letter <= 'z'; letter++ {
+		if pocket.Count(letter) == 0 {
+			continue
+		}
+		for x := int8(0); x < b.Files(); x++ {
+			for y := int8(0); y < b.Ranks(); y++ {
+				s := Square{x: x, y: y}
+				if b.Grid[x][y] == nil && b.canDrop(letter, s) {
+					moves = append(moves, b.dropMove(pieceLetter(letter, color), &s))
+				}
+			}
+		}
+	}
+	return moves
+}
+
+// drops adds the legal drops. A drop only ever blocks, so it is legal
+// without check and answers a single check by a slider when it lands in
+// between.
+func (g *generator) drops() {
+	b := g.b
+	pocket := b.Pocket(g.color)
+	for letter := byte('a'); letter <= 'z'; letter++ {
+		if pocket.Count(letter) == 0 {
+			continue
+		}
+		for x := int8(0); x < b.Files(); x++ {
+			for y := int8(0); y < b.Ranks(); y++ {
+				to := Square{x: x, y: y}
+				if b.Grid[x][y] != nil || !b.canDrop(letter, to) {
+					continue
+				}
+				if g.nfairies > 0 {
+					// a hopper may use the new piece as its hurdle; the
+					// king stands in for it since only the square matters
+					b.Grid[x][y] = b.Grid[g.king.x][g.king.y]
+					checks, _ := g.attackers(g.king)
+					b.Grid[x][y] = nil
+					if checks > 0 {
+						continue
+					}
+				} else if g.checks > 1 || g.checks == 1 && !(g.slider && strictlyBetween(g.king, g.checker, to)) {
+					continue
+				}
+				g.list.push(GenMove{From: to, To: to, Drop: pieceLetter(letter, g.color)})
+			}
+		}
+	}
+}
@@ -0,0 +1,798 @@
+package main
+
+import (
+	"bufio"
+	"compress/zlib"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Endgame tables built here by retrograde analysis. A table holds the
+// distance to mate of every position of one material signature, e.g. KQvK,
+// with either side to move. Castling and en passant are not considered, so
+// signatures with pawns on both sides are not supported.
+
+const egMaxPieces = 4
+
+// values are stored per position for the side to move: 0 is a draw, 255 an
+// illegal position and anything else the plies to mate plus one, odd plies
+// meaning the side to move mates and even plies that it gets mated.
+const (
+	egDraw    = 0
+	egIllegal = 255
+)
+
+const egMagic = "CETB"
+
+type egPiece struct {
+	kind  byte
+	color Color
+}
+
+type EndgameTable struct {
+	Signature string
+	pieces    []egPiece
+	values    []byte
+}
+
+// EndgameTables is a set of tables. Conversions into other material, by a
+// capture or a promotion, are looked up in the other tables of the set.
+type EndgameTables struct {
+	tables map[string]*EndgameTable
+}
+
+func NewEndgameTables() *EndgameTables {
+	return &EndgameTables{tables: map[string]*EndgameTable{}}
+}
+
+// ParseSignature accepts "KQK" as well as "KQvK" and returns the pieces of
+// both sides in the order kings, queens, rooks, bishops, knights, pawns.
+func ParseSignature(s string) (string, string, error) {
+	white, black := s, ""
+	if i := strings.IndexByte(s, 'v'); i >= 0 {
+		white, black = s[:i], s[i+1:]
+	} else if i := strings.IndexByte(s[min(1, len(s)):], 'K'); i >= 0 {
+		white, black = s[:i+1], s[i+1:]
+	}
+	white, black = egSortPieces(white), egSortPieces(black)
+	if !validMaterial(white) || !validMaterial(black) {
+		return "", "", fmt.Errorf("bad material signature %q", s)
+	}
+	if len(white)+len(black) > egMaxPieces {
+		return "", "", fmt.Errorf("%s: at most %d pieces are supported", s, egMaxPieces)
+	}
+	if strings.Contains(white, "P") && strings.Contains(black, "P") {
+		return "", "", fmt.Errorf("%s: pawns on both sides are not supported", s)
+	}
+	return white, black, nil
+}
+
+func egSortPieces(s string) string {
+	sorted := ""
+	for _, kind := range "KQRBNP" {
+		sorted += strings.Repeat(string(kind), strings.Count(s, string(kind)))
+	}
+	if len(sorted) != len(s) {
+		return ""
+	}
+	return sorted
+}
+
+// validMaterial accepts one side's pieces: a single king, written first,
+// and any of queens, rooks, bishops, knights and pawns.
+func validMaterial(s string) bool {
+	return len(s) > 0 && s[0] == 'K' && strings.Trim(s, "KQRBNP") == "" && strings.Count(s, "K") == 1
+}
+
+func egInsufficient(white string, black string) bool {
+	rest := white[1:] + black[1:]
+	return rest == "" || rest == "B" || rest == "N"
+}
+
+func newEndgameTable(white string, black string) *EndgameTable {
+	t := &EndgameTable{Signature: white + "v" + black}
+	for i := 0; i < len(white); i++ {
+		t.pieces = append(t.pieces, egPiece{white[i], White})
+	}
+	for i := 0; i < len(black); i++ {
+		t.pieces = append(t.pieces, egPiece{black[i], Black})
+	}
+	return t
+}
+
+func (t *EndgameTable) size() int {
+	return 2 << (6 * len(t.pieces))
+}
+
+func (t *EndgameTable) index(sq []int, stm Color) int {
+	idx := 0
+	if stm == Black {
+		idx = 1
+	}
+	for i := 0; i < len(sq); i++ {
+		idx = idx<<6 | sq[i]
+	}
+	return idx
+}
+
+func (t *EndgameTable) decode(idx int, sq []int) Color {
+	for i := len(sq) - 1; i >= 0; i-- {
+		sq[i] = idx & 63
+		idx >>= 6
+	}
+	if idx == 1 {
+		return Black
+	}
+	return White
+}
+
+var (
+	egKingSteps   = [][2]int{{-1, -1}, {-1, 0}, {-1, 1}, {0, -1}, {0, 1}, {1, -1}, {1, 0}, {1, 1}}
+	egKnightSteps = [][2]int{{-2, -1}, {-2, 1}, {-1, -2}, {-1, 2}, {1, -2}, {1, 2}, {2, -1}, {2, 1}}
+	egRookRays    = [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}}
+	egBishopRays  = [][2]int{{-1, -1}, {-1, 1}, {1, -1}, {1, 1}}
+)
+
+// egTargets calls fn with every square the piece attacks. Pawns only attack
+// diagonally, their pushes are handled by the move generation.
+func egTargets(p egPiece, from int, occupied *[64]bool, fn func(to int)) {
+	x, y := from%8, from/8
+	step := func(steps [][2]int) {
+		for i := 0; i < len(steps); i++ {
+			tx, ty := x+steps[i][0], y+steps[i][1]
+			if tx >= 0 && tx < 8 && ty >= 0 && ty < 8 {
+				fn(ty*8 + tx)
+			}
+		}
+	}
+	ride := func(rays [][2]int) {
+		for i := 0; i < len(rays); i++ {
+			tx, ty := x+rays[i][0], y+rays[i][1]
+			for tx >= 0 && tx < 8 && ty >= 0 && ty < 8 {
+				fn(ty*8 + tx)
+				if occupied[ty*8+tx] {
+					break
+				}
+				tx, ty = tx+rays[i][0], ty+rays[i][1]
+			}
+		}
+	}
+	switch p.kind {
+	case 'K':
+		step(egKingSteps)
+	case 'N':
+		step(egKnightSteps)
+	case 'R':
+		ride(egRookRays)
+	case 'B':
+		ride(egBishopRays)
+	case 'Q':
+		ride(egRookRays)
+		ride(egBishopRays)
+	case 'P':
+		step([][2]int{{-1, int(p.color)}, {1, int(p.color)}})
+	}
+}
+
+// egAttacked tells whether a piece of the color attacks the square. Pieces
+// with a negative square are off the board.
+func egAttacked(pieces []egPiece, sq []int, target int, by Color) bool {
+	var occupied [64]bool
+	for i := 0; i < len(sq); i++ {
+		if sq[i] >= 0 {
+			occupied[sq[i]] = true
+		}
+	}
+	attacked := false
+	for i := 0; i < len(pieces) && !attacked; i++ {
+		if pieces[i].color != by || sq[i] < 0 {
+			continue
+		}
+		egTargets(pieces[i], sq[i], &occupied, func(to int) {
+			if to == target {
+				attacked = true
+			}
+		})
+	}
+	return attacked
+}
+
+func egKingSquare(pieces []egPiece, sq []int, c Color) int {
+	for i := 0; i < len(pieces); i++ {
+		if pieces[i].kind == 'K' && pieces[i].color == c {
+			return sq[i]
+		}
+	}
+	return -1
+}
+
+func egLegal(pieces []egPiece, sq []int, stm Color) bool {
+	for i := 0; i < len(sq); i++ {
+		if pieces[i].kind == 'P' && (sq[i] < 8 || sq[i] >= 56) {
+			return false
+		}
+		for j := 0; j < i; j++ {
+			if sq[i] == sq[j] {
+				return false
+			}
+		}
+	}
+	return !egAttacked(pieces, sq, egKingSquare(pieces, sq, -stm), stm)
+}
+
+// egMove is a move in the generator's representation: the piece in slot
+// from goes to square to, capturing the piece in slot captured and maybe
+// promoting.
+type egMove struct {
+	slot     int
+	to       int
+	captured int
+	promote  byte
+}
+
+// egMoves generates the legal moves of the side to move.
+func egMoves(pieces []egPiece, sq []int, stm Color) []egMove {
+	var occupied [64]bool
+	owner := [64]int{}
+	for i := 0; i < 64; i++ {
+		owner[i] = -1
+	}
+	for i := 0; i < len(sq); i++ {
+		occupied[sq[i]] = true
+		owner[sq[i]] = i
+	}
+	candidates := []egMove{}
+	for i := 0; i < len(pieces); i++ {
+		p := pieces[i]
+		if p.color != stm {
+			continue
+		}
+		add := func(to int, captured int) {
+			if p.kind == 'P' && (to < 8 || to >= 56) {
+				for _, kind := range []byte("QRBN") {
+					candidates = append(candidates, egMove{i, to, captured, kind})
+				}
+				return
+			}
+			candidates = append(candidates, egMove{i, to, captured, 0})
+		}
+		egTargets(p, sq[i], &occupied, func(to int) {
+			switch {
+			case owner[to] < 0 && p.kind != 'P':
+				add(to, -1)
+			case owner[to] >= 0 && pieces[owner[to]].color != stm:
+				add(to, owner[to])
+			}
+		})
+		if p.kind == 'P' {
+			one := sq[i] + 8*int(stm)
+			if !occupied[one] {
+				add(one, -1)
+				two := one + 8*int(stm)
+				rank := sq[i] / 8
+				if (stm == White && rank == 1 || stm == Black && rank == 6) && !occupied[two] {
+					add(two, -1)
+				}
+			}
+		}
+	}
+
+	moves := []egMove{}
+	child := make([]int, len(sq))
+	for i := 0; i < len(candidates); i++ {
+		m := candidates[i]
+		copy(child, sq)
+		child[m.slot] = m.to
+		if m.captured >= 0 {
+			child[m.captured] = -1
+		}
+		if !egAttacked(pieces, child, egKingSquare(pieces, child, stm), -stm) {
+			moves = append(moves, m)
+		}
+	}
+	return moves
+}
+
+// egChildValue returns the value of the position after a move that leaves
+// the table, for the side to move after it.
+func (set *EndgameTables) egChildValue(pieces []egPiece, sq []int, m egMove, stm Color) (byte, error) {
+	childPieces := []egPiece{}
+	childSq := []int{}
+	for i := 0; i < len(pieces); i++ {
+		if i == m.captured {
+			continue
+		}
+		p := pieces[i]
+		s := sq[i]
+		if i == m.slot {
+			s = m.to
+			if m.promote != 0 {
+				p.kind = m.promote
+			}
+		}
+		childPieces = append(childPieces, p)
+		childSq = append(childSq, s)
+	}
+	return set.value(childPieces, childSq, -stm)
+}
+
+// value looks the position up in the table of its material, colors
+// mirrored when the table is stored for the other side.
+func (set *EndgameTables) value(pieces []egPiece, sq []int, stm Color) (byte, error) {
+	letters := map[Color]string{}
+	for i := 0; i < len(pieces); i++ {
+		letters[pieces[i].color] += string(pieces[i].kind)
+	}
+	white, black := egSortPieces(letters[White]), egSortPieces(letters[Black])
+	mirror := false
+	t := set.tables[white+"v"+black]
+	if t == nil {
+		t = set.tables[black+"v"+white]
+		mirror = true
+	}
+	if t == nil {
+		if egInsufficient(white, black) {
+			return egDraw, nil
+		}
+		return 0, fmt.Errorf("no table for %sv%s", white, black)
+	}
+
+	slots := make([]int, len(t.pieces))
+	used := make([]bool, len(pieces))
+	for i := 0; i < len(t.pieces); i++ {
+		for j := 0; j < len(pieces); j++ {
+			p := pieces[j]
+			s := sq[j]
+			if mirror {
+				p.color = -p.color
+				s ^= 56
+			}
+			if !used[j] && p == t.pieces[i] {
+				used[j] = true
+				slots[i] = s
+				break
+			}
+		}
+	}
+	if mirror {
+		stm = -stm
+	}
+	return t.values[t.index(slots, stm)], nil
+}
+
+// Generate builds the table for the signature, together with the tables of
+// the material it can convert into.
+func (set *EndgameTables) Generate(signature string) (*EndgameTable, error) {
+	white, black, err := ParseSignature(signature)
+	if err != nil {
+		return nil, err
+	}
+	if t := set.tables[white+"v"+black]; t != nil {
+		return t, nil
+	}
+	if t := set.tables[black+"v"+white]; t != nil {
+		return t, nil
+	}
+
+	// every material reachable by one capture or promotion comes first
+	for _, side := range []int{0, 1} {
+		own, other := white, black
+		if side == 1 {
+			own, other = black, white
+		}
+		for i := 1; i < len(own); i++ {
+			reduced := own[:i] + own[i+1:]
+			if err := set.generateSub(reduced, other, side); err != nil {
+				return nil, err
+			}
+			if own[i] != 'P' {
+				continue
+			}
+			for _, kind := range "QRBN" {
+				promoted := egSortPieces(reduced + string(kind))
+				if err := set.generateSub(promoted, other, side); err != nil {
+					return nil, err
+				}
+				for j := 1; j < len(other); j++ {
+					if err := set.generateSub(promoted, other[:j]+other[j+1:], side); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+
+	t := newEndgameTable(white, black)
+	if err := set.retrograde(t); err != nil {
+		return nil, err
+	}
+	set.tables[t.Signature] = t
+	return t, nil
+}
+
+func (set *EndgameTables) generateSub(own string, other string, side int) error {
+	white, black := own, other
+	if side == 1 {
+		white, black = other, own
+	}
+	if egInsufficient(white, black) {
+		return nil
+	}
+	_, err := set.Generate(white + "v" + black)
+	return err
+}
+
+// retrograde fills the table. Mates are found first, then every position
+// one move before a lost position is won and a position is lost once all
+// its moves lead to won positions. Positions never reached are draws.
+func (set *EndgameTables) retrograde(t *EndgameTable) error {
+	n := t.size()
+	t.values = make([]byte, n)
+	// moves not yet known to lose, for positions not decided yet
+	remaining := make([]byte, n)
+	// wins by converting into other material, as plies plus one
+	conversions := make([]byte, n)
+	sq := make([]int, len(t.pieces))
+	maxPlies := 0
+
+	for idx := 0; idx < n; idx++ {
+		stm := t.decode(idx, sq)
+		if !egLegal(t.pieces, sq, stm) {
+			t.values[idx] = egIllegal
+			continue
+		}
+		moves := egMoves(t.pieces, sq, stm)
+		if len(moves) == 0 {
+			if egAttacked(t.pieces, sq, egKingSquare(t.pieces, sq, stm), -stm) {
+				t.values[idx] = 1
+			}
+			continue
+		}
+		count := 0
+		escapes := false
+		lossPlies := 0
+		for i := 0; i < len(moves); i++ {
+			m := moves[i]
+			if m.captured < 0 && m.promote == 0 {
+				count++
+				continue
+			}
+			v, err := set.egChildValue(t.pieces, sq, m, stm)
+			if err != nil {
+				return err
+			}
+			plies := int(v) - 1
+			switch {
+			case v == egDraw:
+				escapes = true
+			case plies%2 == 0:
+				escapes = true
+				if conversions[idx] == 0 || int(conversions[idx]) > plies+2 {
+					conversions[idx] = byte(plies + 2)
+				}
+			default:
+				lossPlies = max(lossPlies, plies+1)
+			}
+		}
+		if escapes {
+			count++
+		}
+		if count == 0 {
+			t.values[idx] = byte(lossPlies + 1)
+			maxPlies = max(maxPlies, lossPlies)
+		}
+		remaining[idx] = byte(count)
+		if conversions[idx] != 0 {
+			maxPlies = max(maxPlies, int(conversions[idx])-1)
+		}
+	}
+
+	prev := make([]int, len(t.pieces))
+	var failed error
+	for plies := 0; plies <= maxPlies; plies++ {
+		v := byte(plies + 1)
+		if plies+1 >= egIllegal {
+			return fmt.Errorf("%s: mates longer than %d plies", t.Signature, egIllegal-2)
+		}
+		for idx := 0; idx < n; idx++ {
+			if t.values[idx] == egDraw && conversions[idx] == v {
+				t.values[idx] = v
+			}
+		}
+		for idx := 0; idx < n; idx++ {
+			if t.values[idx] != v {
+				continue
+			}
+			stm := t.decode(idx, sq)
+			egUnmoves(t.pieces, sq, -stm, func(slot int, from int) {
+				copy(prev, sq)
+				prev[slot] = from
+				p := t.index(prev, -stm)
+				if t.values[p] != egDraw || remaining[p] == 0 {
+					return
+				}
+				if plies%2 == 0 {
+					t.values[p] = byte(plies + 2)
+					maxPlies = max(maxPlies, plies+1)
+					return
+				}
+				remaining[p]--
+				if remaining[p] > 0 {
+					return
+				}
+				loss, err := set.longestDefence(t, prev, -stm)
+				if err != nil {
+					failed = err
+					return
+				}
+				t.values[p] = byte(loss + 1)
+				maxPlies = max(maxPlies, loss)
+			})
+			if failed != nil {
+				return failed
+			}
+		}
+	}
+	return nil
+}
+
+// longestDefence is the number of plies to mate for a lost position: one
+// more than its slowest move.
+func (set *EndgameTables) longestDefence(t *EndgameTable, sq []int, stm Color) (int, error) {
+	moves := egMoves(t.pieces, sq, stm)
+	longest := 0
+	child := make([]int, len(sq))
+	for i := 0; i < len(moves); i++ {
+		m := moves[i]
+		var v byte
+		if m.captured < 0 && m.promote == 0 {
+			copy(child, sq)
+			child[m.slot] = m.to
+			v = t.values[t.index(child, -stm)]
+		} else {
+			var err error
+			v, err = set.egChildValue(t.pieces, sq, m, stm)
+			if err != nil {
+				return 0, err
+			}
+		}
+		longest = max(longest, int(v))
+	}
+	return longest, nil
+}
+
+// egUnmoves calls fn with every square a piece of the color could have come
+// from to its current square without capturing or promoting.
+func egUnmoves(pieces []egPiece, sq []int, c Color, fn func(slot int, from int)) {
+	var occupied [64]bool
+	for i := 0; i < len(sq); i++ {
+		occupied[sq[i]] = true
+	}
+	for i := 0; i < len(pieces); i++ {
+		p := pieces[i]
+		if p.color != c {
+			continue
+		}
+		if p.kind == 'P' {
+			one := sq[i] - 8*int(c)
+			if one < 8 || one >= 56 || occupied[one] {
+				continue
+			}
+			fn(i, one)
+			two := one - 8*int(c)
+			rank := sq[i] / 8
+			if (c == White && rank == 3 || c == Black && rank == 4) && !occupied[two] {
+				fn(i, two)
+			}
+			continue
+		}
+		egTargets(p, sq[i], &occupied, func(from int) {
+			if !occupied[from] {
+				fn(i, from)
+			}
+		})
+	}
+}
+
+// Probe returns 1 when the side on turn wins, -1 when it loses and 0 for a
+// draw, together with the number of plies to mate.
+func (set *EndgameTables) Probe(b *Board, onTurn Color) (int, int, error) {
+	pieces := []egPiece{}
+	sq := []int{}
+	all := b.getPieces()
+	for i := 0; i < len(all); i++ {
+		p := all[i]
+		pieces = append(pieces, egPiece{p.Letter() &^ 0x20, p.Color()})
+		sq = append(sq, int(p.Square().y)*8+int(p.Square().x))
+	}
+	if len(pieces) > egMaxPieces {
+		return 0, 0, errors.New("endgame: too many pieces")
+	}
+	v, err := set.value(pieces, sq, onTurn)
+	if err != nil {
+		return 0, 0, fmt.Errorf("endgame: %v", err)
+	}
+	switch {
+	case v == egIllegal:
+		return 0, 0, errors.New("endgame: illegal position")
+	case v == egDraw:
+		return 0, 0, nil
+	case (v-1)%2 == 1:
+		return 1, int(v) - 1, nil
+	}
+	return -1, int(v) - 1, nil
+}
+
+// BestMove picks the fastest mate when winning, the longest defence when
+// losing and a move keeping the draw otherwise.
+func (set *EndgameTables) BestMove(b *Board, onTurn Color) (*Move, error) {
+	moves := b.possibleMoves(onTurn)
+	var best *Move
+	bestRank := 0
+	for i := 0; i < len(moves); i++ {
+		m := moves[i]
+		b.doMove(m)
+		outcome, plies, err := set.Probe(b, -onTurn)
+		b.undoMove(m)
+		if err != nil {
+			return nil, err
+		}
+		rank := 0
+		switch outcome {
+		case -1:
+			rank = 1000 - plies
+		case 1:
+			rank = -1000 + plies
+		}
+		if best == nil || rank > bestRank {
+			best = m
+			bestRank = rank
+		}
+	}
+	if best == nil {
+		return nil, errors.New("endgame: no legal moves")
+	}
+	return best, nil
+}
+
+// Save writes the table as the magic, the signature on its own line and
+// the zlib compressed values.
+func (t *EndgameTable) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s%s\n", egMagic, t.Signature); err != nil {
+		f.Close()
+		return err
+	}
+	zw := zlib.NewWriter(f)
+	if _, err := zw.Write(t.values); err != nil {
+		f.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func LoadEndgameTable(path string) (*EndgameTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	header, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(header, egMagic) {
+		return nil, fmt.Errorf("%s: not an endgame table", path)
+	}
+	white, black, err := ParseSignature(strings.TrimSpace(header[len(egMagic):]))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	t := newEndgameTable(white, black)
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	t.values = make([]byte, t.size())
+	if _, err := io.ReadFull(zr, t.values); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return t, nil
+}
+
+// LoadEndgameTables reads every .etb file of the directory.
+func LoadEndgameTables(dir string) (*EndgameTables, error) {
+	set := NewEndgameTables()
+	paths, err := filepath.Glob(filepath.Join(dir, "*.etb"))
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(paths); i++ {
+		t, err := LoadEndgameTable(paths[i])
+		if err != nil {
+			return nil, err
+		}
+		set.tables[t.Signature] = t
+	}
+	return set, nil
+}
+
+func endgameCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: chess egtb [generate|probe]")
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "generate":
+		flags := flag.NewFlagSet("egtb generate", flag.ExitOnError)
+		dir := flags.String("dir", ".", "directory to write the tables to")
+		flags.Parse(args[1:])
+
+		set, err := LoadEndgameTables(*dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		existing := map[string]bool{}
+		for sig := range set.tables {
+			existing[sig] = true
+		}
+		for i := 0; i < flags.NArg(); i++ {
+			if _, err := set.Generate(flags.Arg(i)); err != nil {
+				log.Fatal(err)
+			}
+		}
+		for sig, t := range set.tables {
+			if existing[sig] {
+				continue
+			}
+			path := filepath.Join(*dir, sig+".etb")
+			if err := t.Save(path); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println("wrote", path)
+		}
+	case "probe":
+		flags := flag.NewFlagSet("egtb probe", flag.ExitOnError)
+		dir := flags.String("dir", ".", "directory with the tables")
+		fen := flags.String("fen", "", "position to probe")
+		flags.Parse(args[1:])
+
+		set, err := LoadEndgameTables(*dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		g, err := ParseFEN(*fen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		outcome, plies, err := set.Probe(g.Board, g.OnTurn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		switch outcome {
+		case 1:
+			fmt.Printf("win, mate in %d\n", (plies+1)/2)
+		case -1:
+			fmt.Printf("loss, mated in %d\n", plies/2)
+		default:
+			fmt.Println("draw")
+		}
+		if m, err := set.BestMove(g.Board, g.OnTurn); err == nil {
+			fmt.Println("best", g.SAN(m))
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown egtb command %q\n", args[0])
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+const MateScore = 100000
+
+type Engine struct {
+	Depth    int
+	MoveTime time.Duration
+
+	nodes    int
+	deadline time.Time
+	stopped  bool
+}
+
+type SearchResult struct {
+	Move  *Move
+	Score int
+	Depth int
+	Nodes int
+	PV    []*Move
+}
+
+// Search runs an iterative deepening alpha-beta search until either the depth
+// or the move time limit is reached. With no limits it searches to depth 3.
+// The score is from the point of view of the side on turn.
+func (e *Engine) Search(g *Game) SearchResult {
+	e.nodes = 0
+	e.stopped = false
+	e.deadline = time.Time{}
+	if e.MoveTime > 0 {
+		e.deadline = time.Now().Add(e.MoveTime)
+	}
+	maxDepth := e.Depth
+	if maxDepth <= 0 {
+		maxDepth = 3
+		if e.MoveTime > 0 {
+			maxDepth = 64
+		}
+	}
+
+	result := SearchResult{}
+	moves := g.possibleMoves()
+	if len(moves) == 0 {
+		return result
+	}
+	orderMoves(moves)
+	result.Move = moves[0]
+	for depth := 1; depth <= maxDepth; depth++ {
+		best := moves[0]
+		alpha := -MateScore - 1
+		var pv []*Move
+		for i := 0; i < len(moves); i++ {
+			m := moves[i]
+			g.doMove(m)
+			line := []*Move{}
+			score := -e.negamax(g, depth-1, 1, -MateScore-1, -alpha, &line)
+			g.undoMove(m)
+			if e.stopped {
+				break
+			}
+			if score > alpha {
+				alpha = score
+				best = m
+				pv = append([]*Move{m}, line...)
+			}
+		}
+		if e.stopped {
+			break
+		}
+		result = SearchResult{Move: best, Score: alpha, Depth: depth, Nodes: e.nodes, PV: pv}
+		if alpha >= MateScore-depth || alpha <= -MateScore+depth {
+			break
+		}
+		moveToFront(moves, best)
+	}
+	result.Nodes = e.nodes
+	return result
+}
+
+func (e *Engine) negamax(g *Game, depth int, ply int, alpha int, beta int, pv *[]*Move) int {
+	if e.checkTime() {
+		return 0
+	}
+	if g.HalfmoveClock >= 100 || g.isInsufficientMaterial() {
+		return 0
+	}
+	if result, _ := g.variantOutcome(); result != Ongoing {
+		return outcomeScore(result, g.OnTurn, ply)
+	}
+	if depth <= 0 {
+		return e.quiesce(g, alpha, beta)
+	}
+	e.nodes++
+
+	moves := g.possibleMoves()
+	if len(moves) == 0 {
+		if g.Board.inCheck(g.OnTurn) {
+			return -MateScore + ply
+		}
+		return 0
+	}
+	orderMoves(moves)
+	for i := 0; i < len(moves); i++ {
+		m := moves[i]
+		g.doMove(m)
+		line := []*Move{}
+		score := -e.negamax(g, depth-1, ply+1, -beta, -alpha, &line)
+		g.undoMove(m)
+		if e.stopped {
+			return 0
+		}
+		if score >= beta {
+			return beta
+		}
+		if score > alpha {
+			alpha = score
+			*pv = append([]*Move{m}, line...)
+		}
+	}
+	return alpha
+}
+
+func (e *Engine) quiesce(g *Game, alpha int, beta int) int {
+	e.nodes++
+	if result, _ := g.variantOutcome(); result != Ongoing {
+		return outcomeScore(result, g.OnTurn, 0)
+	}
+	standPat := Evaluate(g)
+	if standPat >= beta {
+		return beta
+	}
+	if standPat > alpha {
+		alpha = standPat
+	}
+
+	moves := g.possibleMoves()
+	captures := []*Move{}
+	for i := 0; i < len(moves); i++ {
+		if moves[i].CapturedPiece != nil || moves[i].PromoteTo != nil {
+			captures = append(captures, moves[i])
+		}
+	}
+	orderMoves(captures)
+	for i := 0; i < len(captures); i++ {
+		m := captures[i]
+		g.doMove(m)
+		score := -e.quiesce(g, -beta, -alpha)
+		g.undoMove(m)
+		if e.checkTime() {
+			return 0
+		}
+		if score >= beta {
+			return beta
+		}
+		if score > alpha {
+			alpha = score
+		}
+	}
+	return alpha
+}
+
+func (e *Engine) checkTime() bool {
+	if e.stopped {
+		return true
+	}
+	if e.nodes%256 == 0 && !e.deadline.IsZero() && time.Now().After(e.deadline) {
+		e.stopped = true
+	}
+	return e.stopped
+}
+
+// orderMoves puts promotions and captures first, most valuable victim and
+// least valuable attacker first.
+func orderMoves(moves []*Move) {
+	sort.SliceStable(moves, func(i, j int) bool {
+		return moveOrderScore(moves[i]) > moveOrderScore(moves[j])
+	})
+}
+
+func moveOrderScore(m *Move) int {
+	score := 0
+	if m.PromoteTo != nil {
+		score += PieceValue(m.PromoteTo)
+	}
+	if m.CapturedPiece != nil {
+		score += 10*PieceValue(m.CapturedPiece) - PieceValue(m.Piece)
+	}
+	return score
+}
+
+func moveToFront(moves []*Move, m *Move) {
+	for i := 0; i < len(moves); i++ {
+		if moves[i] == m {
+			copy(moves[1:i+1], moves[:i])
+			moves[0] = m
+			return
+		}
+	}
+}
+
+func PieceValue(p Piece) int {
+	switch p.(type) {
+	case *Pawn:
+		return 100
+	case *Knight:
+		return 320
+	case *Bishop:
+		return 330
+	case *Rook:
+		return 500
+	case *Queen:
+		return 900
+	case *FairyPiece:
+		return p.(*FairyPiece).Def.Value
+	}
+	return 0
+}
+
+// Evaluate scores the position in centipawns from the point of view of the
+// side on turn: material plus piece-square tables.
+func Evaluate(g *Game) int {
+	score := 0
+	pieces := g.Board.getPieces()
+	for i := 0; i < len(pieces); i++ {
+		p := pieces[i]
+		value := PieceValue(p) + squareBonus(p)
+		if p.Color() == White {
+			score += value
+		} else {
+			score -= value
+		}
+	}
+	score += g.Board.Pocket(White).value() - g.Board.Pocket(Black).value()
+	// in antichess material is a burden
+	if g.Board.Rules().Antichess {
+		score = -score
+	}
+	return score * int(g.OnTurn)
+}
+
+// squareBonus looks the piece up in the 8x8 tables; other boards are
+// scaled onto them.
+func squareBonus(p Piece) int {
+	sq := p.Square()
+	b := p.Board()
+	x := int8(int(sq.x) * int(Size) / int(b.Files()))
+	y := int8(int(sq.y) * int(Size) / int(b.Ranks()))
+	if p.Color() == Black {
+		y = int8(int(b.Ranks()-1-sq.y) * int(Size) / int(b.Ranks()))
+	}
+	index := int(Size-1-y)*int(Size) + int(x)
+	switch p.(type) {
+	case *Pawn:
+		return pawnTable[index]
+	case *Knight:
+		return knightTable[index]
+	case *Bishop:
+		return bishopTable[index]
+	case *Rook:
+		return rookTable[index]
+	case *Queen:
+		return queenTable[index]
+	case *King:
+		return kingTable[index]
+	}
+	return 0
+}
+
+// Piece-square tables from White's point of view, eighth rank first.
+var pawnTable = [64]int{
+	0, 0, 0, 0, 0, 0, 0, 0,
+	50, 50, 50, 50, 50, 50, 50, 50,
+	10, 10, 20, 30, 30, 20, 10, 10,
+	5, 5, 10, 25, 25, 10, 5, 5,
+	0, 0, 0, 20, 20, 0, 0, 0,
+	5, -5, -10, 0, 0, -10, -5, 5,
+	5, 10, 10, -20, -20, 10, 10, 5,
+	0, 0, 0, 0, 0, 0, 0, 0,
+}
+
+var knightTable = [64]int{
+	-50, -40, -30, -30, -30, -30, -40, -50,
+	-40, -20, 0, 0, 0, 0, -20, -40,
+	-30, 0, 10, 15, 15, 10, 0, -30,
+	-30, 5, 15, 20, 20, 15, 5, -30,
+	-30, 0, 15, 20, 20, 15, 0, -30,
+	-30, 5, 10, 15, 15, 10, 5, -30,
+	-40, -20, 0, 5, 5, 0, -20, -40,
+	-50, -40, -30, -30, -30, -30, -40, -50,
+}
+
+var bishopTable = [64]int{
+	-20, -10, -10, -10, -10, -10, -10, -20,
+	-10, 0, 0, 0, 0, 0, 0, -10,
+	-10, 0, 5, 10, 10, 5, 0, -10,
+	-10, 5, 5, 10, 10, 5, 5, -10,
+	-10, 0, 10, 10, 10, 10, 0, -10,
+	-10, 10, 10, 10, 10, 10, 10, -10,
+	-10, 5, 0, 0, 0, 0, 5, -10,
+	-20, -10, -10, -10, -10, -10, -10, -20,
+}
+
+var rookTable = [64]int{
+	0, 0, 0, 0, 0, 0, 0, 0,
+	5, 10, 10, 10, 10, 10, 10, 5,
+	-5, 0, 0, 0, 0, 0, 0, -5,
+	-5, 0, 0, 0, 0, 0, 0, -5,
+	-5, 0, 0, 0, 0, 0, 0, -5,
+	-5, 0, 0, 0, 0, 0, 0, -5,
+	-5, 0, 0, 0, 0, 0, 0, -5,
+	0, 0, 0, 5, 5, 0, 0, 0,
+}
+
+var queenTable = [64]int{
+	-20, -10, -10, -5, -5, -10, -10, -20,
+	-10, 0, 0, 0, 0, 0, 0, -10,
+	-10, 0, 5, 5, 5, 5, 0, -10,
+	-5, 0, 5, 5, 5, 5, 0, -5,
+	0, 0, 5, 5, 5, 5, 0, -5,
+	-10, 5, 5, 5, 5, 5, 0, -10,
+	-10, 0, 5, 0, 0, 0, 0, -10,
+	-20, -10, -10, -5, -5, -10, -10, -20,
+}
+
+var kingTable = [64]int{
+	-30, -40, -40, -50, -50, -40, -40, -30,
+	-30, -40, -40, -50, -50, -40, -40, -30,
+	-30, -40, -40, -50, -50, -40, -40, -30,
+	-30, -40, -40, -50, -50, -40, -40, -30,
+	-20, -30, -30, -40, -40, -30, -30, -20,
+	-10, -20, -20, -20, -20, -20, -20, -10,
+	20, 20, 0, 0, 0, 0, 20, 20,
+	20, 30, 10, 0, 0, 10, 30, 20,
+}
+
+// allocateTime decides how long to think with the given time left on the
+// clock.
+func allocateTime(left time.Duration, increment time.Duration) time.Duration {
+	t := left/30 + increment*3/4
+	if t > left/2 {
+		t = left / 2
+	}
+	return t
+}
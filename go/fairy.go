@@ -0,0 +1,395 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// betzaAtoms are the basic leaps of Betza's notation, given for the first
+// direction; the others follow by symmetry.
+var betzaAtoms = map[byte]Vector{
+	'W': Vector{x: 0, y: 1},
+	'F': Vector{x: 1, y: 1},
+	'D': Vector{x: 0, y: 2},
+	'N': Vector{x: 1, y: 2},
+	'A': Vector{x: 2, y: 2},
+	'H': Vector{x: 0, y: 3},
+	'C': Vector{x: 1, y: 3},
+	'Z': Vector{x: 2, y: 3},
+	'G': Vector{x: 3, y: 3},
+}
+
+// betzaCompounds are the shorthands for the usual pieces; an atom written
+// twice rides.
+var betzaCompounds = map[byte]string{
+	'K': "WF",
+	'R': "WW",
+	'B': "FF",
+	'Q': "WWFF",
+}
+
+// betzaMove is one component of a piece's move: a leap repeated up to limit
+// times in each of its directions.
+type betzaMove struct {
+	vectors []Vector
+	// limit is 1 for a leaper and 0 for a rider that goes any distance
+	limit   int8
+	move    bool
+	capture bool
+	// hop is 'p' for a piece that jumps a hurdle and goes on like a rider
+	// beyond it, 'g' for one that lands right behind the hurdle
+	hop byte
+}
+
+// ParseBetza reads a move description in Betza's notation: atoms W, F, D,
+// N, A, H, C, Z, G and the shorthands K, R, B, Q. Doubling an atom makes
+// it ride and a number after it limits the distance. Lowercase prefixes
+// restrict the atom that follows: m moves only, c captures only, p and g
+// make it a hopper, f, b, l, r, v and s keep the forward, backward, left,
+// right, vertical or sideways directions.
+func ParseBetza(s string) ([]betzaMove, error) {
+	moves := []betzaMove{}
+	for i := 0; i < len(s); {
+		mods := ""
+		for i < len(s) && s[i] >= 'a' && s[i] <= 'z' {
+			mods += string(s[i])
+			i++
+		}
+		if i == len(s) {
+			return nil, fmt.Errorf("betza %q: modifiers %q without an atom", s, mods)
+		}
+		atom := s[i]
+		i++
+		atoms := string(atom)
+		if compound, ok := betzaCompounds[atom]; ok {
+			atoms = compound
+		} else if _, ok := betzaAtoms[atom]; !ok {
+			return nil, fmt.Errorf("betza %q: unknown atom %c", s, atom)
+		}
+		rides := false
+		if i < len(s) && s[i] == atom {
+			rides = true
+			i++
+		}
+		var limit int8 = 1
+		if rides {
+			limit = 0
+		}
+		start := i
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		ranged := i > start
+		if ranged {
+			n, err := strconv.Atoi(s[start:i])
+			if err != nil || n > int(MaxSize) {
+				return nil, fmt.Errorf("betza %q: bad range %q", s, s[start:i])
+			}
+			limit = int8(n)
+		}
+
+		m := betzaMove{}
+		dirs := ""
+		for j := 0; j < len(mods); j++ {
+			switch mods[j] {
+			case 'm':
+				m.move = true
+			case 'c':
+				m.capture = true
+			case 'p', 'g':
+				m.hop = mods[j]
+			case 'f', 'b', 'l', 'r', 'v', 's':
+				dirs += string(mods[j])
+			default:
+				return nil, fmt.Errorf("betza %q: unknown modifier %c", s, mods[j])
+			}
+		}
+		if !m.move && !m.capture {
+			m.move, m.capture = true, true
+		}
+		for j := 0; j < len(atoms); j++ {
+			c := m
+			c.limit = limit
+			if j+1 < len(atoms) && atoms[j+1] == atoms[j] {
+				if !ranged {
+					c.limit = 0
+				}
+				j++
+			}
+			// a hopper looks for its hurdle along the whole line
+			if c.hop != 0 {
+				c.limit = 0
+			}
+			c.vectors = betzaVectors(betzaAtoms[atoms[j]], dirs)
+			if len(c.vectors) == 0 {
+				return nil, fmt.Errorf("betza %q: no %c moves in directions %q", s, atoms[j], dirs)
+			}
+			moves = append(moves, c)
+		}
+	}
+	if len(moves) == 0 {
+		return nil, fmt.Errorf("betza %q: no moves", s)
+	}
+	return moves, nil
+}
+
+// betzaVectors turns an atom into its distinct directions, as seen by
+// White, keeping those named in dirs if there are any.
+func betzaVectors(atom Vector, dirs string) []Vector {
+	vectors := []Vector{}
+	for _, v := range []Vector{
+		{x: atom.x, y: atom.y}, {x: -atom.x, y: atom.y}, {x: atom.x, y: -atom.y}, {x: -atom.x, y: -atom.y},
+		{x: atom.y, y: atom.x}, {x: -atom.y, y: atom.x}, {x: atom.y, y: -atom.x}, {x: -atom.y, y: -atom.x},
+	} {
+		seen := false
+		for i := 0; i < len(vectors); i++ {
+			if vectors[i] == v {
+				seen = true
+			}
+		}
+		if seen || !betzaDirection(v, dirs) {
+			continue
+		}
+		vectors = append(vectors, v)
+	}
+	return vectors
+}
+
+func betzaDirection(v Vector, dirs string) bool {
+	if dirs == "" {
+		return true
+	}
+	x, y := abs(int(v.x)), abs(int(v.y))
+	for i := 0; i < len(dirs); i++ {
+		switch dirs[i] {
+		case 'f':
+			if v.y > 0 {
+				return true
+			}
+		case 'b':
+			if v.y < 0 {
+				return true
+			}
+		case 'l':
+			if v.x < 0 {
+				return true
+			}
+		case 'r':
+			if v.x > 0 {
+				return true
+			}
+		case 'v':
+			if y > x {
+				return true
+			}
+		case 's':
+			if x > y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PieceDef declares a piece by its moves. Letter is lowercase and is what
+// FEN, SAN and promotions use.
+type PieceDef struct {
+	Name   string
+	Letter byte
+	Betza  string
+	Value  int
+
+	moves []betzaMove
+}
+
+// PieceDefs holds the declared pieces by letter.
+var PieceDefs = map[byte]*PieceDef{}
+
+// DefinePiece declares a piece so that FEN, move parsing and promotions
+// know its letter.
+func DefinePiece(name string, letter byte, betza string, value int) (*PieceDef, error) {
+	letter |= 0x20
+	if letter < 'a' || letter > 'z' {
+		return nil, fmt.Errorf("piece %s: letter %c is not a letter", name, letter)
+	}
+	switch letter {
+	case 'k', 'q', 'r', 'b', 'n', 'p':
+		return nil, fmt.Errorf("piece %s: letter %c belongs to a standard piece", name, letter)
+	}
+	if other, ok := PieceDefs[letter]; ok {
+		return nil, fmt.Errorf("piece %s: letter %c is taken by the %s", name, letter, other.Name)
+	}
+	moves, err := ParseBetza(betza)
+	if err != nil {
+		return nil, fmt.Errorf("piece %s: %v", name, err)
+	}
+	def := &PieceDef{Name: name, Letter: letter, Betza: betza, Value: value, moves: moves}
+	PieceDefs[letter] = def
+	return def, nil
+}
+
+func mustDefinePiece(name string, letter byte, betza string, value int) *PieceDef {
+	def, err := DefinePiece(name, letter, betza, value)
+	if err != nil {
+		panic(err)
+	}
+	return def
+}
+
+var (
+	Archbishop  = mustDefinePiece("archbishop", 'a', "BN", 875)
+	Chancellor  = mustDefinePiece("chancellor", 'c', "RN", 900)
+	Amazon      = mustDefinePiece("amazon", 'm', "QN", 1300)
+	Camel       = mustDefinePiece("camel", 'l', "C", 250)
+	Zebra       = mustDefinePiece("zebra", 'z', "Z", 250)
+	Grasshopper = mustDefinePiece("grasshopper", 'g', "gQ", 200)
+	Nightrider  = mustDefinePiece("nightrider", 'i', "NN", 500)
+)
+
+type fairyTarget struct {
+	to      Square
+	capture bool
+}
+
+// maxFairyTargets bounds the targets of a piece: one per square.
+const maxFairyTargets = int(MaxSize) * int(MaxSize)
+
+// targets appends the squares a piece of the color on from can move to.
+func (d *PieceDef) targets(b *Board, from Square, color Color, buf []fairyTarget) []fairyTarget {
+	for i := 0; i < len(d.moves); i++ {
+		m := &d.moves[i]
+		for j := 0; j < len(m.vectors); j++ {
+			v := m.vectors[j]
+			if color == Black {
+				v = Vector{x: -v.x, y: -v.y}
+			}
+			sq := from
+			past := m.hop == 0
+			for n := int8(1); m.limit == 0 || n <= m.limit; n++ {
+				sq.x += v.x
+				sq.y += v.y
+				if !b.Contains(&sq) {
+					break
+				}
+				p := b.Grid[sq.x][sq.y]
+				if !past {
+					past = p != nil
+					continue
+				}
+				if p == nil {
+					if m.move {
+						buf = addFairyTarget(buf, fairyTarget{to: sq})
+					}
+					if m.hop == 'g' {
+						break
+					}
+					continue
+				}
+				if m.capture && p.Color() != color {
+					buf = addFairyTarget(buf, fairyTarget{to: sq, capture: true})
+				}
+				break
+			}
+		}
+	}
+	return buf
+}
+
+// addFairyTarget skips squares another component already reaches.
+func addFairyTarget(buf []fairyTarget, t fairyTarget) []fairyTarget {
+	for i := 0; i < len(buf); i++ {
+		if buf[i].to == t.to {
+			return buf
+		}
+	}
+	return append(buf, t)
+}
+
+// attacks tells whether a piece of the color on from could capture on to,
+// were an enemy piece standing there.
+func (d *PieceDef) attacks(b *Board, from Square, color Color, to Square) bool {
+	for i := 0; i < len(d.moves); i++ {
+		m := &d.moves[i]
+		if !m.capture {
+			continue
+		}
+		for j := 0; j < len(m.vectors); j++ {
+			v := m.vectors[j]
+			if color == Black {
+				v = Vector{x: -v.x, y: -v.y}
+			}
+			sq := from
+			past := m.hop == 0
+			for n := int8(1); m.limit == 0 || n <= m.limit; n++ {
+				sq.x += v.x
+				sq.y += v.y
+				if !b.Contains(&sq) {
+					break
+				}
+				occupied := sq == to || b.Grid[sq.x][sq.y] != nil
+				if !past {
+					if sq == to {
+						break
+					}
+					past = occupied
+					continue
+				}
+				if sq == to {
+					return true
+				}
+				if occupied || m.hop == 'g' {
+					break
+				}
+			}
+		}
+	}
+	return false
+}
+
+// FairyPiece is any piece declared with DefinePiece.
+type FairyPiece struct {
+	PieceBase
+	Def *PieceDef
+}
+
+func (f *FairyPiece) PossibleMoves() []*Move {
+	var buf [maxFairyTargets]fairyTarget
+	targets := f.Def.targets(f.board, *f.square, f.color, buf[:0])
+	moves := []*Move{}
+	for i := 0; i < len(targets); i++ {
+		end := &Square{x: targets[i].to.x, y: targets[i].to.y}
+		m := &Move{Piece: f, Start: f.Square(), End: end}
+		if targets[i].capture {
+			m.CapturedPiece = f.board.GetPiece(end)
+		}
+		moves = append(moves, m)
+	}
+	return moves
+}
+
+// Symbol is the letter, there being no figurines for most fairy pieces.
+func (f *FairyPiece) Symbol() string {
+	return string(f.Letter())
+}
+
+func (f *FairyPiece) Letter() byte {
+	if f.color == White {
+		return f.Def.Letter &^ 0x20
+	}
+	return f.Def.Letter
+}
+
+// fairyAttackers returns the fairy pieces of the color that attack the
+// square.
+func (b *Board) fairyAttackers(s *Square, color Color) []Piece {
+	attackers := []Piece{}
+	for x := int8(0); x < b.Files(); x++ {
+		for y := int8(0); y < b.Ranks(); y++ {
+			f, ok := b.Grid[x][y].(*FairyPiece)
+			if ok && f.color == color && f.Def.attacks(b, Square{x: x, y: y}, color, *s) {
+				attackers = append(attackers, f)
+			}
+		}
+	}
+	return attackers
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const StartFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+func ParseFEN(fen string) (*Game, error) {
+	return ParseFENRules(fen, nil)
+}
+
+// ParseFENRules reads a FEN of a game played by the rules, which are
+// inferred from the board's size and pocket when nil. A checks field, as in
+// "3+3" after the en passant square, asks for three-check.
+func ParseFENRules(fen string, rules *RuleSet) (*Game, error) {
+	fields := strings.Fields(fen)
+	if len(fields) < 4 || len(fields) > 7 {
+		return nil, fmt.Errorf("fen: expected 6 fields, got %d", len(fields))
+	}
+	checks := ""
+	if len(fields) == 5 || len(fields) == 7 {
+		checks = fields[4]
+		fields = append(fields[:4], fields[5:]...)
+		if rules == nil {
+			rules = ThreeCheck
+		}
+	}
+
+	// crazyhouse appends the pocket in brackets
+	placement, pocket := fields[0], ""
+	drops := false
+	if i := strings.IndexByte(placement, '['); i >= 0 {
+		if !strings.HasSuffix(placement, "]") {
+			return nil, fmt.Errorf("fen: unclosed pocket")
+		}
+		placement, pocket = placement[:i], placement[i+1:len(placement)-1]
+		drops = true
+	}
+	ranks := strings.Split(placement, "/")
+	if len(ranks) > int(MaxSize) {
+		return nil, fmt.Errorf("fen: %d ranks", len(ranks))
+	}
+	if rules == nil {
+		inferred, err := ruleSetFor(rankWidth(ranks[0]), int8(len(ranks)), drops)
+		if err != nil {
+			return nil, fmt.Errorf("fen: %v", err)
+		}
+		rules = inferred
+	} else if rankWidth(ranks[0]) != rules.Files || int8(len(ranks)) != rules.Ranks {
+		return nil, fmt.Errorf("fen: %s is played on %dx%d", rules.Name, rules.Files, rules.Ranks)
+	} else if drops && !rules.Drops {
+		return nil, fmt.Errorf("fen: pocket in %s", rules.Name)
+	} else if checks != "" && rules.Checks == 0 {
+		return nil, fmt.Errorf("fen: checks field in %s", rules.Name)
+	}
+	board := &Board{rules: rules}
+	if err := board.setPockets(pocket); err != nil {
+		return nil, err
+	}
+	files := board.Files()
+	pieces := []Piece{}
+	for i := 0; i < len(ranks); i++ {
+		y := board.Ranks() - 1 - int8(i)
+		x := int8(0)
+		for j := 0; j < len(ranks[i]); j++ {
+			c := ranks[i][j]
+			if c >= '0' && c <= '9' {
+				// empty squares may take two digits on wide boards
+				n := int8(c - '0')
+				if j+1 < len(ranks[i]) && ranks[i][j+1] >= '0' && ranks[i][j+1] <= '9' {
+					j++
+					n = n*10 + int8(ranks[i][j]-'0')
+				}
+				x += n
+				continue
+			}
+			if c == '~' {
+				if len(pieces) == 0 || *pieces[len(pieces)-1].Square() != (Square{x: x - 1, y: y}) {
+					return nil, fmt.Errorf("fen: promotion mark without a piece")
+				}
+				pieces[len(pieces)-1].base().promoted = true
+				continue
+			}
+			if x >= files {
+				return nil, fmt.Errorf("fen: rank %d is too long", y+1)
+			}
+			piece := newPiece(c, &Square{x: x, y: y}, board)
+			if piece == nil {
+				return nil, fmt.Errorf("fen: unknown piece %q", c)
+			}
+			pieces = append(pieces, piece)
+			x++
+		}
+		if x != files {
+			return nil, fmt.Errorf("fen: rank %d has %d files", y+1, x)
+		}
+	}
+	board.setPieces(pieces)
+
+	if err := board.checkPlacement(); err != nil {
+		return nil, fmt.Errorf("fen: %v", err)
+	}
+
+	game := &Game{Board: board, FullmoveNumber: 1}
+	switch fields[1] {
+	case "w":
+		game.OnTurn = White
+	case "b":
+		game.OnTurn = Black
+	default:
+		return nil, fmt.Errorf("fen: bad side to move %q", fields[1])
+	}
+
+	if err := board.setCastlingRights(fields[2]); err != nil {
+		return nil, fmt.Errorf("fen: %v", err)
+	}
+
+	if fields[3] != "-" {
+		sq, err := board.ParseSquare(fields[3])
+		if err != nil || !board.passedOver(sq, -game.OnTurn) {
+			return nil, fmt.Errorf("fen: no double step passed over %q", fields[3])
+		}
+		board.EnpassantSquare = sq
+	}
+
+	if len(fields) == 6 {
+		halfmove, err := strconv.Atoi(fields[4])
+		if err != nil || halfmove < 0 {
+			return nil, fmt.Errorf("fen: bad halfmove clock %q", fields[4])
+		}
+		fullmove, err := strconv.Atoi(fields[5])
+		if err != nil || fullmove < 1 {
+			return nil, fmt.Errorf("fen: bad fullmove number %q", fields[5])
+		}
+		game.HalfmoveClock = halfmove
+		game.FullmoveNumber = fullmove
+	}
+
+	if checks != "" {
+		if err := game.setChecksLeft(checks); err != nil {
+			return nil, err
+		}
+	}
+
+	if board.inCheck(-game.OnTurn) {
+		return nil, fmt.Errorf("fen: side not to move is in check")
+	}
+	game.StartFEN = game.FEN()
+	if game.StartFEN == StartFEN {
+		game.StartFEN = ""
+	}
+	return game, nil
+}
+
+// rankWidth counts the files of one rank of a FEN placement.
+func rankWidth(rank string) int8 {
+	width := 0
+	for i := 0; i < len(rank); i++ {
+		c := rank[i]
+		if c == '~' {
+			continue
+		}
+		if c < '0' || c > '9' {
+			width++
+			continue
+		}
+		n := int(c - '0')
+		if i+1 < len(rank) && rank[i+1] >= '0' && rank[i+1] <= '9' {
+			i++
+			n = n*10 + int(rank[i]-'0')
+		}
+		width += n
+	}
+	if width > int(MaxSize) {
+		return 0
+	}
+	return int8(width)
+}
+
+// setCastlingRights marks kings and rooks as moved unless the FEN castling
+// field allows them to castle.
+func (b *Board) setCastlingRights(field string) error {
+	rights := map[byte]bool{}
+	if field != "-" {
+		for i := 0; i < len(field); i++ {
+			if strings.IndexByte("KQkq", field[i]) < 0 {
+				return fmt.Errorf("bad castling field %q", field)
+			}
+			rights[field[i]] = true
+		}
+	}
+	corners := []struct {
+		right byte
+		color Color
+		x     int8
+	}{
+		{'K', White, b.Files() - 1},
+		{'Q', White, 0},
+		{'k', Black, b.Files() - 1},
+		{'q', Black, 0},
+	}
+	kingFile := b.Rules().KingFile
+	for i := 0; i < len(corners); i++ {
+		c := corners[i]
+		y := int8(0)
+		if c.color == Black {
+			y = b.Ranks() - 1
+		}
+		king, ok := b.Grid[kingFile][y].(*King)
+		rook, rookOk := b.Grid[c.x][y].(*Rook)
+		if !ok || king.color != c.color || !rookOk || rook.color != c.color {
+			if rights[c.right] {
+				return fmt.Errorf("castling right %q without king and rook", c.right)
+			}
+			continue
+		}
+		if !rights[c.right] {
+			rook.moveCounter = 1
+		}
+	}
+
+	pieces := b.getPieces()
+	for i := 0; i < len(pieces); i++ {
+		king, ok := pieces[i].(*King)
+		if !ok {
+			continue
+		}
+		short := byte('K')
+		long := byte('Q')
+		home := int8(0)
+		if king.color == Black {
+			short = 'k'
+			long = 'q'
+			home = b.Ranks() - 1
+		}
+		if *king.square != (Square{x: kingFile, y: home}) || !rights[short] && !rights[long] {
+			king.moveCounter = 1
+		}
+	}
+	return nil
+}
+
+func (b *Board) castlingRights() string {
+	rights := ""
+	corners := []struct {
+		right byte
+		color Color
+		x     int8
+	}{
+		{'K', White, b.Files() - 1},
+		{'Q', White, 0},
+		{'k', Black, b.Files() - 1},
+		{'q', Black, 0},
+	}
+	for i := 0; i < len(corners); i++ {
+		c := corners[i]
+		y := int8(0)
+		if c.color == Black {
+			y = b.Ranks() - 1
+		}
+		king, ok := b.Grid[b.Rules().KingFile][y].(*King)
+		if !ok || king.color != c.color || king.moveCounter > 0 {
+			continue
+		}
+		rook, ok := b.Grid[c.x][y].(*Rook)
+		if !ok || rook.color != c.color || rook.moveCounter > 0 {
+			continue
+		}
+		rights += string(c.right)
+	}
+	if rights == "" {
+		return "-"
+	}
+	return rights
+}
+
+func (b *Board) placement() string {
+	return b.placementSeen(nil)
+}
+
+// placementSeen writes '?' on the squares not seen; nil sees them all.
+func (b *Board) placementSeen(seen *[MaxSize][MaxSize]bool) string {
+	var sb strings.Builder
+	for y := b.Ranks() - 1; y >= 0; y-- {
+		empty := 0
+		for x := int8(0); x < b.Files(); x++ {
+			p := b.Grid[x][y]
+			if seen != nil && !seen[x][y] {
+				if empty > 0 {
+					sb.WriteString(strconv.Itoa(empty))
+					empty = 0
+				}
+				sb.WriteByte('?')
+				continue
+			}
+			if p == nil {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				sb.WriteString(strconv.Itoa(empty))
+				empty = 0
+			}
+			sb.WriteByte(p.Letter())
+			if p.base().promoted && b.Rules().Drops {
+				sb.WriteByte('~')
+			}
+		}
+		if empty > 0 {
+			sb.WriteString(strconv.Itoa(empty))
+		}
+		if y > 0 {
+			sb.WriteByte('/')
+		}
+	}
+	if b.Rules().Drops {
+		sb.WriteString(b.pocketString())
+	}
+	return sb.String()
+}
+
+// positionKey identifies a position for repetition detection: the first four
+// FEN fields and, in three-check, the checks left.
+func (g *Game) positionKey() string {
+	side := "w"
+	if g.OnTurn == Black {
+		side = "b"
+	}
+	ep := "-"
+	if g.Board.EnpassantSquare != nil {
+		ep = g.Board.EnpassantSquare.String()
+	}
+	key := g.Board.placement() + " " + side + " " + g.Board.castlingRights() + " " + ep
+	if g.Board.Rules().Checks > 0 {
+		key += " " + g.checksLeft()
+	}
+	return key
+}
+
+func (g *Game) FEN() string {
+	return fmt.Sprintf("%s %d %d", g.positionKey(), g.HalfmoveClock, g.FullmoveNumber)
+}
+
+// ParseSquare reads a square such as e4, or a10 on a tall board, anywhere
+// on the largest board.
+func ParseSquare(s string) (*Square, error) {
+	if len(s) < 2 || len(s) > 3 || s[0] < 'a' || s[0] >= 'a'+byte(MaxSize) || s[1] < '1' || s[1] > '9' {
+		return nil, fmt.Errorf("bad square %q", s)
+	}
+	rank := int(s[1] - '0')
+	if len(s) == 3 {
+		if s[2] < '0' || s[2] > '9' {
+			return nil, fmt.Errorf("bad square %q", s)
+		}
+		rank = rank*10 + int(s[2]-'0')
+	}
+	if rank > int(MaxSize) {
+		return nil, fmt.Errorf("bad square %q", s)
+	}
+	return &Square{x: int8(s[0] - 'a'), y: int8(rank - 1)}, nil
+}
+
+// ParseSquare reads a square and checks that it is on the board.
+func (b *Board) ParseSquare(s string) (*Square, error) {
+	sq, err := ParseSquare(s)
+	if err != nil {
+		return nil, err
+	}
+	if !b.Contains(sq) {
+		return nil, fmt.Errorf("square %s is off the %dx%d board", s, b.Files(), b.Ranks())
+	}
+	return sq, nil
+}
+
+func newPiece(letter byte, square *Square, board *Board) Piece {
+	color := White
+	if letter >= 'a' {
+		color = Black
+	}
+	base := PieceBase{color: color, square: square, board: board}
+	switch letter | 0x20 {
+	case 'k':
+		return &King{base}
+	case 'q':
+		return &Queen{StraightGoer{base}}
+	case 'r':
+		return &Rook{StraightGoer{base}}
+	case 'b':
+		return &Bishop{StraightGoer{base}}
+	case 'n':
+		return &Knight{base}
+	case 'p':
+		return &Pawn{base}
+	}
+	if def, ok := PieceDefs[letter|0x20]; ok {
+		return &FairyPiece{base, def}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+func TestParseSquare(t *testing.T) {
+	standard := &Board{rules: Standard}
+	gardner := &Board{rules: Gardner}
+	capablanca := &Board{rules: Capablanca}
+	squares := []struct {
+		board *Board
+		s     string
+		ok    bool
+	}{
+		{standard, "e4", true},
+		{standard, "h8", true},
+		{standard, "e:", false},
+		{standard, "e0", false},
+		{standard, "i1", false},
+		{standard, "a9", false},
+		{standard, "a10", false},
+		{gardner, "e5", true},
+		{gardner, "f1", false},
+		{gardner, "a6", false},
+		{capablanca, "j8", true},
+		{capablanca, "k1", false},
+		{capablanca, "a01", false},
+		{capablanca, "a1x", false},
+	}
+	for i := 0; i < len(squares); i++ {
+		sq, err := squares[i].board.ParseSquare(squares[i].s)
+		if (err == nil) != squares[i].ok {
+			t.Errorf("%s on %dx%d: error %v", squares[i].s, squares[i].board.Files(), squares[i].board.Ranks(), err)
+			continue
+		}
+		if err == nil && sq.String() != squares[i].s {
+			t.Errorf("%s reads as %s", squares[i].s, sq)
+		}
+	}
+	// ranks run to two digits on the largest board
+	if sq, err := ParseSquare("a10"); err != nil || sq.y != 9 {
+		t.Errorf("a10: %v %v", sq, err)
+	}
+	if _, err := ParseSquare("a11"); err == nil {
+		t.Error("a11 parses")
+	}
+}
+
+func TestEnPassantField(t *testing.T) {
+	fens := []struct {
+		fen string
+		ok  bool
+	}{
+		{"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1", true},
+		{"rnbqkbnr/ppp1pppp/8/3pP3/8/8/PPPP1PPP/RNBQKBNR w KQkq d6 0 2", true},
+		// the pawn never left e2
+		{"4k3/8/8/8/8/8/3PP3/4K3 w - e3 0 1", false},
+		// White's own double step cannot be taken by White
+		{"4k3/8/8/8/4P3/8/8/4K3 w - e3 0 1", false},
+		{"4k3/8/8/8/4P3/8/8/4K3 b - e4 0 1", false},
+		{"4k3/8/8/8/4P3/8/8/4K3 b - e9 0 1", false},
+	}
+	for i := 0; i < len(fens); i++ {
+		_, err := ParseFEN(fens[i].fen)
+		if (err == nil) != fens[i].ok {
+			t.Errorf("%s: error %v", fens[i].fen, err)
+		}
+	}
+}
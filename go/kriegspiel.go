@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+)
+
+// View is what a player is shown of the board.
+type View int
+
+const (
+	// FullView shows every piece.
+	FullView View = iota
+	// KriegspielView shows the player's own pieces only.
+	KriegspielView
+	// FogView shows the player's own pieces and the squares they can move
+	// to, with whatever stands there.
+	FogView
+)
+
+var viewNames = []string{"full", "kriegspiel", "fog"}
+
+func (v View) String() string {
+	return viewNames[v]
+}
+
+func ParseView(name string) (View, error) {
+	if name == "" {
+		return FullView, nil
+	}
+	for i := 0; i < len(viewNames); i++ {
+		if viewNames[i] == strings.ToLower(name) {
+			return View(i), nil
+		}
+	}
+	return FullView, fmt.Errorf("unknown view %q", name)
+}
+
+// seen marks the squares the color sees. Observers, the zero color, see
+// nothing but in the full view.
+func (b *Board) seen(color Color, view View) *[MaxSize][MaxSize]bool {
+	var seen [MaxSize][MaxSize]bool
+	for x := int8(0); x < b.Files(); x++ {
+		for y := int8(0); y < b.Ranks(); y++ {
+			p := b.Grid[x][y]
+			seen[x][y] = view == FullView || p != nil && p.Color() == color
+		}
+	}
+	if view != FogView || color == 0 {
+		return &seen
+	}
+	// the squares reached stop at the first piece in the way, which is
+	// what the fog lets through
+	pieces := b.getPieces()
+	for i := 0; i < len(pieces); i++ {
+		if pieces[i].Color() != color {
+			continue
+		}
+		moves := pieces[i].PossibleMoves()
+		for j := 0; j < len(moves); j++ {
+			seen[moves[j].End.x][moves[j].End.y] = true
+		}
+	}
+	return &seen
+}
+
+// viewPlacement is the FEN placement as the color sees it, '?' standing
+// for each square it does not.
+func (b *Board) viewPlacement(color Color, view View) string {
+	return b.placementSeen(b.seen(color, view))
+}
+
+// PrintView prints the board as the color sees it.
+func (b *Board) PrintView(color Color, view View) {
+	b.printSeen(os.Stdout, b.seen(color, view))
+}
+
+// Umpire referees a game the players cannot see in full. It checks every
+// attempted move against the legal moves and announces what Kriegspiel lets
+// both players know: illegal attempts, captures, checks and the result.
+type Umpire struct {
+	Game *Game
+	View View
+	// Log holds every announcement so far.
+	Log []string
+}
+
+func NewUmpire(g *Game, view View) *Umpire {
+	return &Umpire{Game: g, View: view, Log: []string{}}
+}
+
+// Judge returns the legal move the side on turn attempted, or nil after
+// announcing that the attempt is illegal. The player then tries again.
+func (u *Umpire) Judge(s string) *Move {
+	m, err := u.Game.ParseMove(s)
+	if err != nil {
+		u.announce("illegal")
+		return nil
+	}
+	return m
+}
+
+// Played announces the move the game has just made and returns what was
+// said.
+func (u *Umpire) Played(m *Move) []string {
+	g := u.Game
+	n := len(u.Log)
+	u.announce(colorName(-g.OnTurn) + " moved")
+	if m.CapturedPiece != nil {
+		what := "piece"
+		if _, ok := m.CapturedPiece.(*Pawn); ok {
+			what = "pawn"
+		}
+		u.announce(what + " captured on " + m.CapturedPiece.Square().String())
+	}
+	checks := g.Board.Checkers(g.OnTurn)
+	for i := 0; i < len(checks); i++ {
+		u.announce(checkDirection(g.Board, g.Board.getKing(g.OnTurn).Square(), checks[i]))
+	}
+	if result, reason := g.Outcome(); result != Ongoing {
+		u.announce(reason + ", " + string(result))
+	}
+	return u.Log[n:]
+}
+
+func (u *Umpire) announce(s string) {
+	u.Log = append(u.Log, s)
+}
+
+// checkDirection names the line a check comes along, the diagonals told
+// apart by their length through the king's square.
+func checkDirection(b *Board, king *Square, checker Piece) string {
+	s := checker.Square()
+	dx, dy := s.x-king.x, s.y-king.y
+	switch {
+	case checker.Letter()|0x20 == 'n':
+		return "knight check"
+	case dx == 0:
+		return "check on the file"
+	case dy == 0:
+		return "check on the rank"
+	case dx != dy && dx != -dy:
+		return "check"
+	}
+	other := Vector{x: 1, y: -1}
+	v := Vector{x: 1, y: 1}
+	if dx != dy {
+		v, other = other, v
+	}
+	if diagonalLength(b, king, v) >= diagonalLength(b, king, other) {
+		return "check on the long diagonal"
+	}
+	return "check on the short diagonal"
+}
+
+// diagonalLength counts the squares of the diagonal through the square.
+func diagonalLength(b *Board, s *Square, v Vector) int {
+	n := 1
+	for _, d := range []Vector{v, {x: -v.x, y: -v.y}} {
+		sq := s.AddVector(&d)
+		for b.Contains(sq) {
+			n++
+			sq = sq.AddVector(&d)
+		}
+	}
+	return n
+}
+
+// hiddenGame plays random attempts through an umpire, printing the board as
+// the player on turn sees it and everything the umpire says.
+func hiddenGame(game *Game, view View) {
+	umpire := NewUmpire(game, view)
+	for game.Result() == Ongoing {
+		color := game.OnTurn
+		fmt.Printf("%s sees:\n", colorName(color))
+		game.Board.PrintView(color, view)
+		// the player only knows its own pieces, so it tries their moves
+		// blindly until one is legal
+		candidates := game.Board.moveCandidates(color)
+		var m *Move
+		for m == nil {
+			try := candidates[rand.Intn(len(candidates))].UCI()
+			if m = umpire.Judge(try); m == nil {
+				fmt.Printf("%s tries %s: illegal\n", colorName(color), try)
+			}
+		}
+		fmt.Printf("%s plays %s\n", colorName(color), game.SAN(m))
+		game.doMove(m)
+		said := umpire.Played(m)
+		for i := 0; i < len(said); i++ {
+			fmt.Println("umpire: " + said[i])
+		}
+		fmt.Println("-----------------")
+	}
+	game.Board.Print()
+}
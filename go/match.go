@@ -0,0 +1,633 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Player interface {
+	Name() string
+	NewGame() error
+	// Think returns the move to play and its score in centipawns from the
+	// point of view of the side on turn. The game is left unchanged.
+	Think(g *Game, clock *Clock) (*Move, int, error)
+	Close()
+}
+
+// PlayerSpec describes a match participant, written on the command line as
+// comma separated key=value pairs, e.g. "name=new,depth=4" or
+// "cmd=/usr/bin/stockfish,option.Hash=16". The book key gives the built-in
+// engine a Polyglot opening book.
+type PlayerSpec struct {
+	Name     string
+	Cmd      string
+	Depth    int
+	MoveTime time.Duration
+	Book     string
+	Options  map[string]string
+}
+
+func ParsePlayerSpec(s string) (*PlayerSpec, error) {
+	spec := &PlayerSpec{Options: map[string]string{}}
+	if s == "" {
+		return spec, nil
+	}
+	pairs := strings.Split(s, ",")
+	for i := 0; i < len(pairs); i++ {
+		kv := strings.SplitN(pairs[i], "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("player: expected key=value, got %q", pairs[i])
+		}
+		key, value := kv[0], kv[1]
+		switch {
+		case key == "name":
+			spec.Name = value
+		case key == "cmd":
+			spec.Cmd = value
+		case key == "depth":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("player: bad depth %q", value)
+			}
+			spec.Depth = n
+		case key == "movetime":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("player: bad movetime %q", value)
+			}
+			spec.MoveTime = d
+		case key == "book":
+			spec.Book = value
+		case strings.HasPrefix(key, "option."):
+			spec.Options[strings.TrimPrefix(key, "option.")] = value
+		default:
+			return nil, fmt.Errorf("player: unknown key %q", key)
+		}
+	}
+	return spec, nil
+}
+
+func (spec *PlayerSpec) setDefaultName(name string) {
+	if spec.Name != "" {
+		return
+	}
+	spec.Name = name
+	if spec.Cmd != "" {
+		spec.Name = filepath.Base(strings.Fields(spec.Cmd)[0])
+	}
+}
+
+func (spec *PlayerSpec) New() (Player, error) {
+	if spec.Cmd != "" {
+		return startUCIPlayer(spec)
+	}
+	p := &enginePlayer{spec: spec}
+	if spec.Book != "" {
+		book, err := LoadBook(spec.Book)
+		if err != nil {
+			return nil, err
+		}
+		p.book = book
+	}
+	return p, nil
+}
+
+// enginePlayer plays with the built-in search, from its opening book while
+// it has one.
+type enginePlayer struct {
+	spec *PlayerSpec
+	book *Book
+}
+
+func (p *enginePlayer) Name() string {
+	return p.spec.Name
+}
+
+func (p *enginePlayer) NewGame() error {
+	return nil
+}
+
+func (p *enginePlayer) Think(g *Game, clock *Clock) (*Move, int, error) {
+	if p.book != nil {
+		if m := p.book.PickMove(g); m != nil {
+			return m, 0, nil
+		}
+	}
+	e := &Engine{Depth: p.spec.Depth, MoveTime: p.spec.MoveTime}
+	if clock != nil {
+		budget := allocateTime(clock.Remaining(g.OnTurn), clock.Increment)
+		if e.MoveTime == 0 || budget < e.MoveTime {
+			e.MoveTime = budget
+		}
+	}
+	result := e.Search(g)
+	if result.Move == nil {
+		return nil, 0, fmt.Errorf("%s: no legal move", p.Name())
+	}
+	return result.Move, result.Score, nil
+}
+
+func (p *enginePlayer) Close() {}
+
+type Opening struct {
+	FEN   string
+	Moves []string
+}
+
+func (o Opening) Start() (*Game, error) {
+	g := InitGame()
+	if o.FEN != "" {
+		parsed, err := ParseFEN(o.FEN)
+		if err != nil {
+			return nil, err
+		}
+		g = parsed
+	}
+	for i := 0; i < len(o.Moves); i++ {
+		m, err := g.ParseMove(o.Moves[i])
+		if err != nil {
+			return nil, err
+		}
+		g.doMove(m)
+	}
+	return g, nil
+}
+
+// LoadOpenings reads an EPD file (one position per line) or a PGN file whose
+// games are cut after the given number of plies, 0 keeps them whole.
+func LoadOpenings(path string, plies int) ([]Opening, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	openings := []Opening{}
+	if strings.EqualFold(filepath.Ext(path), ".pgn") {
+		games, err := ParsePGN(f)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(games); i++ {
+			moves := games[i].Moves
+			if plies > 0 && len(moves) > plies {
+				moves = moves[:plies]
+			}
+			openings = append(openings, Opening{FEN: games[i].Tags["FEN"], Moves: moves})
+		}
+	} else {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 4 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			openings = append(openings, Opening{FEN: strings.Join(fields[:4], " ") + " 0 1"})
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; i < len(openings); i++ {
+		if _, err := openings[i].Start(); err != nil {
+			return nil, fmt.Errorf("%s: opening %d: %v", path, i+1, err)
+		}
+	}
+	return openings, nil
+}
+
+// Adjudication ends games early once both engines agree on the outcome.
+// Counts are in moves of each side, zero disables the rule.
+type Adjudication struct {
+	DrawMoveNumber  int
+	DrawMoveCount   int
+	DrawScore       int
+	ResignMoveCount int
+	ResignScore     int
+	MaxMoves        int
+}
+
+type adjudicator struct {
+	rules      Adjudication
+	drawPlies  int
+	whitePlies int
+	blackPlies int
+}
+
+// update takes the score of the move just searched by the side on turn.
+func (a *adjudicator) update(g *Game, score int) (Result, string) {
+	r := a.rules
+	white := score * int(g.OnTurn)
+
+	if r.DrawMoveCount > 0 && g.FullmoveNumber >= r.DrawMoveNumber && abs(score) <= r.DrawScore {
+		a.drawPlies++
+	} else {
+		a.drawPlies = 0
+	}
+	if r.DrawMoveCount > 0 && a.drawPlies >= 2*r.DrawMoveCount {
+		return Draw, "adjudication"
+	}
+
+	if r.ResignMoveCount > 0 && white >= r.ResignScore {
+		a.whitePlies++
+	} else {
+		a.whitePlies = 0
+	}
+	if r.ResignMoveCount > 0 && white <= -r.ResignScore {
+		a.blackPlies++
+	} else {
+		a.blackPlies = 0
+	}
+	if r.ResignMoveCount > 0 && a.whitePlies >= 2*r.ResignMoveCount {
+		return WhiteWins, "adjudication"
+	}
+	if r.ResignMoveCount > 0 && a.blackPlies >= 2*r.ResignMoveCount {
+		return BlackWins, "adjudication"
+	}
+	return Ongoing, ""
+}
+
+type MatchConfig struct {
+	First        *PlayerSpec
+	Second       *PlayerSpec
+	Games        int
+	Concurrency  int
+	Openings     []Opening
+	Time         time.Duration
+	Increment    time.Duration
+	Adjudication Adjudication
+}
+
+// GameRecord is one finished game. Games are played in pairs on the same
+// opening with colors swapped.
+type GameRecord struct {
+	Pair        int
+	FirstWhite  bool
+	Result      Result
+	Reason      string
+	PGN         string
+	FirstPoints float64
+}
+
+type matchJob struct {
+	pair       int
+	firstWhite bool
+	opening    Opening
+}
+
+// Run plays the match and hands every finished game to onGame, one at a
+// time. Closing stop cancels the games not yet started.
+func (cfg *MatchConfig) Run(onGame func(GameRecord), stop <-chan struct{}) {
+	openings := cfg.Openings
+	if len(openings) == 0 {
+		openings = []Opening{{}}
+	}
+	pairs := (cfg.Games + 1) / 2
+	jobs := make(chan matchJob)
+	go func() {
+		defer close(jobs)
+		for p := 0; p < pairs; p++ {
+			for i := 0; i < 2; i++ {
+				job := matchJob{pair: p, firstWhite: i == 0, opening: openings[p%len(openings)]}
+				select {
+				case jobs <- job:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+
+	records := make(chan GameRecord)
+	var wg sync.WaitGroup
+	concurrency := cfg.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cfg.worker(jobs, records)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(records)
+	}()
+	for rec := range records {
+		onGame(rec)
+	}
+}
+
+func (cfg *MatchConfig) worker(jobs <-chan matchJob, records chan<- GameRecord) {
+	first, err := cfg.First.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer first.Close()
+	second, err := cfg.Second.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer second.Close()
+	for job := range jobs {
+		records <- cfg.playGame(job, first, second)
+	}
+}
+
+func (cfg *MatchConfig) playGame(job matchJob, first Player, second Player) GameRecord {
+	white, black := first, second
+	if !job.firstWhite {
+		white, black = second, first
+	}
+	players := map[Color]Player{White: white, Black: black}
+	g, _ := job.opening.Start()
+	var clock *Clock
+	if cfg.Time > 0 {
+		clock = NewClock(cfg.Time, cfg.Increment)
+	}
+	adj := &adjudicator{rules: cfg.Adjudication}
+	for _, p := range players {
+		if err := p.NewGame(); err != nil {
+			g.Adjudicate(resultAgainst(g, p, players), "engine error: "+err.Error())
+		}
+	}
+
+	plies := 0
+	for g.Result() == Ongoing {
+		if cfg.Adjudication.MaxMoves > 0 && plies >= 2*cfg.Adjudication.MaxMoves {
+			g.Adjudicate(Draw, "max moves")
+			break
+		}
+		color := g.OnTurn
+		m, score, err := players[color].Think(g, clock)
+		if err != nil {
+			g.Adjudicate(resultAgainst(g, players[color], players), "engine error: "+err.Error())
+			break
+		}
+		if clock != nil && !clock.Press(color) {
+			if g.canMate(-color) {
+				g.Adjudicate(resultAgainst(g, players[color], players), "time forfeit")
+			} else {
+				g.Adjudicate(Draw, "time forfeit vs insufficient material")
+			}
+			break
+		}
+		if result, reason := adj.update(g, score); result != Ongoing {
+			g.doMove(m)
+			g.Adjudicate(result, reason)
+			break
+		}
+		g.doMove(m)
+		plies++
+	}
+
+	result, reason := g.Outcome()
+	round := fmt.Sprintf("%d.1", job.pair+1)
+	if !job.firstWhite {
+		round = fmt.Sprintf("%d.2", job.pair+1)
+	}
+	tags := map[string]string{
+		"Event":       "chess match",
+		"Round":       round,
+		"White":       white.Name(),
+		"Black":       black.Name(),
+		"Termination": reason,
+	}
+	if clock != nil {
+		tags["TimeControl"] = fmt.Sprintf("%g+%g", cfg.Time.Seconds(), cfg.Increment.Seconds())
+	}
+	points := 0.5
+	if result == WhiteWins {
+		points = 1
+	} else if result == BlackWins {
+		points = 0
+	}
+	if !job.firstWhite {
+		points = 1 - points
+	}
+	return GameRecord{
+		Pair:        job.pair,
+		FirstWhite:  job.firstWhite,
+		Result:      result,
+		Reason:      reason,
+		PGN:         g.PGN(tags),
+		FirstPoints: points,
+	}
+}
+
+// resultAgainst is the result in which loser loses.
+func resultAgainst(g *Game, loser Player, players map[Color]Player) Result {
+	if players[White] == loser {
+		return BlackWins
+	}
+	return WhiteWins
+}
+
+func match(args []string) {
+	flags := flag.NewFlagSet("match", flag.ExitOnError)
+	engine1 := flags.String("engine1", "", "first player, e.g. name=new,depth=3 or cmd=./engine")
+	engine2 := flags.String("engine2", "", "second player")
+	games := flags.Int("games", 10, "number of games, rounded up to pairs; the maximum with -sprt")
+	concurrency := flags.Int("concurrency", 1, "games played at the same time")
+	openingsFile := flags.String("openings", "", "EPD or PGN file with openings")
+	plies := flags.Int("plies", 8, "plies taken from PGN openings, 0 for all")
+	tc := flags.String("tc", "", "time control as base+increment, e.g. 10s+100ms")
+	pgnOut := flags.String("pgnout", "", "file to write the games to")
+	drawMoveNumber := flags.Int("draw-movenumber", 40, "first move number for draw adjudication")
+	drawMoveCount := flags.Int("draw-movecount", 0, "moves within the draw score needed, 0 disables")
+	drawScore := flags.Int("draw-score", 10, "draw adjudication score in centipawns")
+	resignMoveCount := flags.Int("resign-movecount", 0, "moves beyond the resign score needed, 0 disables")
+	resignScore := flags.Int("resign-score", 800, "resign adjudication score in centipawns")
+	maxMoves := flags.Int("maxmoves", 0, "adjudicate a draw after this many moves, 0 disables")
+	sprtSpec := flags.String("sprt", "", "stop early by SPRT, e.g. elo0=0,elo1=5,alpha=0.05,beta=0.05")
+	sprtModel := flags.String("sprt-model", "pentanomial", "SPRT model: pentanomial or trinomial")
+	flags.Parse(args)
+
+	var sprt *SPRT
+	if *sprtSpec != "" {
+		s, err := parseSPRT(*sprtSpec, *sprtModel)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sprt = s
+	}
+
+	cfg, err := newMatchConfig(*engine1, *engine2, *openingsFile, *plies, *tc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.Games = *games
+	cfg.Concurrency = *concurrency
+	cfg.Adjudication = Adjudication{
+		DrawMoveNumber:  *drawMoveNumber,
+		DrawMoveCount:   *drawMoveCount,
+		DrawScore:       *drawScore,
+		ResignMoveCount: *resignMoveCount,
+		ResignScore:     *resignScore,
+		MaxMoves:        *maxMoves,
+	}
+
+	var out *os.File
+	if *pgnOut != "" {
+		out, err = os.Create(*pgnOut)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer out.Close()
+	}
+
+	wins, draws, losses := 0, 0, 0
+	played := 0
+	pairs := map[int][]float64{}
+	pentanomial := make([]int, 5)
+	stop := make(chan struct{})
+	decided := false
+	cfg.Run(func(rec GameRecord) {
+		played++
+		switch rec.FirstPoints {
+		case 1:
+			wins++
+		case 0:
+			losses++
+		default:
+			draws++
+		}
+		white, black := cfg.First.Name, cfg.Second.Name
+		if !rec.FirstWhite {
+			white, black = black, white
+		}
+		fmt.Printf("Finished game %d (%s vs %s): %s {%s}\n", played, white, black, rec.Result, rec.Reason)
+		fmt.Printf("Score of %s vs %s: %d - %d - %d [%.3f] %d\n", cfg.First.Name, cfg.Second.Name, wins, losses, draws, (float64(wins)+float64(draws)/2)/float64(played), played)
+		if out != nil {
+			fmt.Fprintln(out, rec.PGN)
+		}
+
+		pairs[rec.Pair] = append(pairs[rec.Pair], rec.FirstPoints)
+		if len(pairs[rec.Pair]) < 2 {
+			return
+		}
+		pentanomial[int(2*(pairs[rec.Pair][0]+pairs[rec.Pair][1]))]++
+		delete(pairs, rec.Pair)
+		if sprt == nil || decided {
+			return
+		}
+		counts := []int{losses, draws, wins}
+		if sprt.Pentanomial {
+			counts = pentanomial
+		}
+		llr := sprt.LLR(counts)
+		lower, upper := sprt.Bounds()
+		fmt.Printf("SPRT: llr %.3f, lbound %.3f, ubound %.3f, pentanomial %v\n", llr, lower, upper, pentanomial)
+		if status := sprt.Status(llr); status != "" {
+			fmt.Printf("SPRT: %s accepted\n", status)
+			decided = true
+			close(stop)
+		}
+	}, stop)
+	printMatchSummary(cfg.First.Name, cfg.Second.Name, wins, draws, losses)
+	if sprt != nil && !decided {
+		fmt.Println("SPRT: no decision")
+	}
+}
+
+func parseSPRT(spec string, model string) (*SPRT, error) {
+	s := &SPRT{Elo0: 0, Elo1: 5, Alpha: 0.05, Beta: 0.05}
+	switch model {
+	case "pentanomial":
+		s.Pentanomial = true
+	case "trinomial":
+	default:
+		return nil, fmt.Errorf("sprt: unknown model %q", model)
+	}
+	pairs := strings.Split(spec, ",")
+	for i := 0; i < len(pairs); i++ {
+		kv := strings.SplitN(pairs[i], "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("sprt: expected key=value, got %q", pairs[i])
+		}
+		v, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("sprt: bad value %q", kv[1])
+		}
+		switch kv[0] {
+		case "elo0":
+			s.Elo0 = v
+		case "elo1":
+			s.Elo1 = v
+		case "alpha":
+			s.Alpha = v
+		case "beta":
+			s.Beta = v
+		default:
+			return nil, fmt.Errorf("sprt: unknown key %q", kv[0])
+		}
+	}
+	if s.Elo1 <= s.Elo0 || s.Alpha <= 0 || s.Alpha >= 1 || s.Beta <= 0 || s.Beta >= 1 {
+		return nil, fmt.Errorf("sprt: need elo0 < elo1 and 0 < alpha, beta < 1")
+	}
+	return s, nil
+}
+
+func newMatchConfig(engine1, engine2, openingsFile string, plies int, tc string) (*MatchConfig, error) {
+	first, err := ParsePlayerSpec(engine1)
+	if err != nil {
+		return nil, err
+	}
+	second, err := ParsePlayerSpec(engine2)
+	if err != nil {
+		return nil, err
+	}
+	first.setDefaultName("engine1")
+	second.setDefaultName("engine2")
+	if first.Name == second.Name {
+		second.Name += "-2"
+	}
+	cfg := &MatchConfig{First: first, Second: second}
+	if openingsFile != "" {
+		cfg.Openings, err = LoadOpenings(openingsFile, plies)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if tc != "" {
+		parts := strings.SplitN(tc, "+", 2)
+		cfg.Time, err = time.ParseDuration(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("bad time control %q", tc)
+		}
+		if len(parts) == 2 {
+			cfg.Increment, err = time.ParseDuration(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("bad time control %q", tc)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+func printMatchSummary(first string, second string, wins int, draws int, losses int) {
+	n := wins + draws + losses
+	if n == 0 {
+		return
+	}
+	elo, margin := EloMargin(wins, draws, losses)
+	fmt.Printf("Score of %s vs %s: %d - %d - %d [%.3f] %d\n", first, second, wins, losses, draws, (float64(wins)+float64(draws)/2)/float64(n), n)
+	fmt.Printf("Elo difference: %.1f +/- %.1f, LOS: %.1f %%, DrawRatio: %.1f %%\n", elo, margin, 100*LOS(wins, losses), 100*float64(draws)/float64(n))
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
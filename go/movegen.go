@@ -0,0 +1,540 @@
+package main
+
+// MaxMoves bounds the number of legal moves in any position; the record is
+// 218 in chess, but drops in crazyhouse go past 500.
+const MaxMoves = 1024
+
+type MoveFlag uint8
+
+const (
+	FlagCapture MoveFlag = 1 << iota
+	FlagEnpassant
+	FlagDoublePush
+	FlagShortCastle
+	FlagLongCastle
+)
+
+// GenMove is a move as a plain value. Promote is the lowercase letter of the
+// promotion piece or 0. Drop is the FEN letter of a piece dropped on To,
+// From being To.
+type GenMove struct {
+	From    Square
+	To      Square
+	Promote byte
+	Drop    byte
+	Flags   MoveFlag
+}
+
+func (m GenMove) UCI() string {
+	if m.Drop != 0 {
+		return string(m.Drop&^0x20) + "@" + m.To.String()
+	}
+	uci := m.From.String() + m.To.String()
+	if m.Promote != 0 {
+		uci += string(m.Promote)
+	}
+	return uci
+}
+
+// MoveList is a fixed-capacity move buffer meant to be reused, one per ply.
+type MoveList struct {
+	moves [MaxMoves]GenMove
+	n     int
+}
+
+func (l *MoveList) Len() int {
+	return l.n
+}
+
+func (l *MoveList) At(i int) GenMove {
+	return l.moves[i]
+}
+
+func (l *MoveList) push(m GenMove) {
+	l.moves[l.n] = m
+	l.n++
+}
+
+// generator holds what GenerateMoves needs to know about the side to move
+// to decide legality while producing the moves.
+type generator struct {
+	b       *Board
+	color   Color
+	list    *MoveList
+	king    Square
+	checks  int
+	checker Square
+	slider  bool
+	pinned  [MaxSize][MaxSize]bool
+	// with fairy pieces on the board, atomic rules or checks forbidden
+	// every move is tried on the grid
+	fairies   [maxFairyTargets]Square
+	nfairies  int
+	targets   [maxFairyTargets]fairyTarget
+	atomic    bool
+	noChecks  bool
+	antichess bool
+	kingless  bool
+	enemyKing Square
+}
+
+// GenerateMoves fills the list with the legal moves of the color. It works
+// on values only and does not allocate.
+func (b *Board) GenerateMoves(color Color, list *MoveList) {
+	list.n = 0
+	g := generator{b: b, color: color, list: list, atomic: b.Rules().Atomic, noChecks: b.Rules().NoChecks, antichess: b.Rules().Antichess}
+	found := false
+	for x := int8(0); x < b.Files(); x++ {
+		for y := int8(0); y < b.Ranks(); y++ {
+			switch p := b.Grid[x][y].(type) {
+			case *King:
+				if p.color == color {
+					g.king = Square{x: x, y: y}
+					found = true
+				} else {
+					g.enemyKing = Square{x: x, y: y}
+				}
+			case *FairyPiece:
+				g.fairies[g.nfairies] = Square{x: x, y: y}
+				g.nfairies++
+			}
+		}
+	}
+	switch {
+	case g.antichess, !found && !g.atomic:
+		// the king is an ordinary piece or, as for the horde, missing:
+		// nothing checks or pins
+		g.kingless = true
+	case !found:
+		return
+	default:
+		g.findChecks()
+	}
+
+	for x := int8(0); x < b.Files(); x++ {
+		for y := int8(0); y < b.Ranks(); y++ {
+			p := b.Grid[x][y]
+			if p == nil || p.Color() != color {
+				continue
+			}
+			from := Square{x: x, y: y}
+			switch p.(type) {
+			case *Pawn:
+				g.pawnMoves(from)
+			case *Knight:
+				for i := 0; i < len(knightVectors); i++ {
+					g.step(from, knightVectors[i])
+				}
+			case *King:
+				for d := 0; d < len(DirVectors[0]); d++ {
+					g.step(from, DirVectors[0][d])
+				}
+				g.castling(p.(*King))
+			case *FairyPiece:
+				g.fairyMoves(from, p.(*FairyPiece).Def)
+			default:
+				for d := DirectionName(0); d < DirectionName(len(DirVectors[0])); d++ {
+					if slides(p, d) {
+						g.slide(from, DirVectors[0][d])
+					}
+				}
+			}
+		}
+	}
+	if b.Rules().Drops {
+		g.drops()
+	}
+	if g.antichess {
+		list.keepCaptures()
+	}
+}
+
+// findChecks notes the checks on the king and the pieces pinned to it.
+func (g *generator) findChecks() {
+	b := g.b
+	g.checks, g.checker = g.attackers(g.king)
+	if g.atomic && b.nextToKing(g.king, -g.color) {
+		g.checks = 0
+	}
+	if g.checks == 1 {
+		switch b.Grid[g.checker.x][g.checker.y].(type) {
+		case *Rook, *Bishop, *Queen:
+			g.slider = true
+		}
+	}
+	g.findPins()
+}
+
+// attacksOn counts the pieces of the color attacking the square and
+// returns one of them.
+func (b *Board) attacksOn(s Square, color Color) (int, Square) {
+	count := 0
+	var from Square
+	b.eachAttacker(s, color, func(t Square) {
+		count++
+		from = t
+	})
+	return count, from
+}
+
+// attackers is attacksOn with the fairy pieces of the other side.
+func (g *generator) attackers(s Square) (int, Square) {
+	count, from := g.b.attacksOn(s, -g.color)
+	for i := 0; i < g.nfairies; i++ {
+		f := g.fairies[i]
+		p, ok := g.b.Grid[f.x][f.y].(*FairyPiece)
+		if ok && p.color != g.color && p.Def.attacks(g.b, f, p.color, s) {
+			count++
+			from = f
+		}
+	}
+	return count, from
+}
+
+// findPins marks the pieces pinned to the king.
+func (g *generator) findPins() {
+	g.b.eachPin(g.king, g.color, func(pinned Square, pinner Square, d DirectionName) {
+		g.pinned[pinned.x][pinned.y] = true
+	})
+}
+
+// collinear tells whether three squares lie on one line.
+func collinear(a Square, b Square, c Square) bool {
+	return int(b.x-a.x)*int(c.y-a.y)-int(b.y-a.y)*int(c.x-a.x) == 0
+}
+
+// strictlyBetween tells whether c lies on the segment from a to b,
+// endpoints excluded. a and b are assumed to share a line.
+func strictlyBetween(a Square, b Square, c Square) bool {
+	if !collinear(a, b, c) {
+		return false
+	}
+	return int(c.x-a.x)*int(b.x-c.x)+int(c.y-a.y)*int(b.y-c.y) > 0
+}
+
+// add checks a pseudo-legal move and pushes it, expanding promotions.
+func (g *generator) add(from Square, to Square, flags MoveFlag, promotes bool) {
+	if !g.kingless && !g.legal(from, to, flags) {
+		return
+	}
+	b := g.b
+	if !promotes {
+		g.list.push(GenMove{From: from, To: to, Flags: flags})
+		return
+	}
+	letters := b.Rules().Promotions
+	for i := 0; i < len(letters); i++ {
+		g.list.push(GenMove{From: from, To: to, Promote: letters[i], Flags: flags})
+	}
+}
+
+// legal tells whether the pseudo-legal move keeps the king safe.
+func (g *generator) legal(from Square, to Square, flags MoveFlag) bool {
+	b := g.b
+	if g.atomic && from == g.king && flags&FlagCapture != 0 {
+		return false
+	}
+	if g.nfairies > 0 || g.atomic || g.noChecks {
+		if !g.safe(from, to, flags) {
+			return false
+		}
+	} else if from == g.king {
+		if flags&(FlagShortCastle|FlagLongCastle) == 0 {
+			king := b.Grid[from.x][from.y]
+			b.Grid[from.x][from.y] = nil
+			checks, _ := b.attacksOn(to, -g.color)
+			b.Grid[from.x][from.y] = king
+			if checks > 0 {
+				return false
+			}
+		}
+	} else {
+		if g.checks > 1 {
+			return false
+		}
+		if flags&FlagEnpassant != 0 {
+			if !g.enpassantLegal(from, to) {
+				return false
+			}
+		} else {
+			if g.pinned[from.x][from.y] && !collinear(g.king, from, to) {
+				return false
+			}
+			if g.checks == 1 && to != g.checker && !(g.slider && strictlyBetween(g.king, g.checker, to)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// safe plays the move on the grid, the rook too when castling and the
+// explosion of an atomic capture, and looks for attacks on the king and,
+// where checks are forbidden, on the other king.
+func (g *generator) safe(from Square, to Square, flags MoveFlag) bool {
+	b := g.b
+	piece, target := b.Grid[from.x][from.y], b.Grid[to.x][to.y]
+	captured := to
+	if flags&FlagEnpassant != 0 {
+		captured = Square{x: to.x, y: from.y}
+	}
+	pawn := b.Grid[captured.x][captured.y]
+	b.Grid[captured.x][captured.y] = nil
+	b.Grid[from.x][from.y] = nil
+	b.Grid[to.x][to.y] = piece
+	var rookFrom, rookTo Square
+	castles := flags&(FlagShortCastle|FlagLongCastle) != 0
+	if castles {
+		rookFrom, rookTo = Square{x: b.Files() - 1, y: to.y}, Square{x: b.Files() - 3, y: to.y}
+		if flags&FlagLongCastle != 0 {
+			rookFrom, rookTo = Square{x: 0, y: to.y}, Square{x: 3, y: to.y}
+		}
+		b.Grid[rookTo.x][rookTo.y] = b.Grid[rookFrom.x][rookFrom.y]
+		b.Grid[rookFrom.x][rookFrom.y] = nil
+	}
+	var blown [8]Square
+	var blownPieces [8]Piece
+	n := 0
+	if g.atomic && flags&FlagCapture != 0 {
+		b.Grid[to.x][to.y] = nil
+		for dx := int8(-1); dx <= 1; dx++ {
+			for dy := int8(-1); dy <= 1; dy++ {
+				sq := Square{x: to.x + dx, y: to.y + dy}
+				if dx == 0 && dy == 0 || !b.Contains(&sq) || b.Grid[sq.x][sq.y] == nil {
+					continue
+				}
+				if _, ok := b.Grid[sq.x][sq.y].(*Pawn); ok {
+					continue
+				}
+				blown[n], blownPieces[n] = sq, b.Grid[sq.x][sq.y]
+				b.Grid[sq.x][sq.y] = nil
+				n++
+			}
+		}
+	}
+	king := g.king
+	if from == g.king {
+		king = to
+	}
+	safe := !g.attacked(king)
+	if g.atomic {
+		if _, ok := b.Grid[king.x][king.y].(*King); !ok {
+			safe = false
+		} else if _, ok := b.Grid[g.enemyKing.x][g.enemyKing.y].(*King); !ok {
+			// blowing up the other king wins even from check
+			safe = true
+		}
+	}
+	if g.noChecks && safe {
+		checks, _ := b.attacksOn(g.enemyKing, g.color)
+		safe = checks == 0
+	}
+	for i := n - 1; i >= 0; i-- {
+		b.Grid[blown[i].x][blown[i].y] = blownPieces[i]
+	}
+	if castles {
+		b.Grid[rookFrom.x][rookFrom.y] = b.Grid[rookTo.x][rookTo.y]
+		b.Grid[rookTo.x][rookTo.y] = nil
+	}
+	b.Grid[to.x][to.y] = target
+	b.Grid[from.x][from.y] = piece
+	b.Grid[captured.x][captured.y] = pawn
+	return safe
+}
+
+// attacked tells whether the king of the side to move would be attacked on
+// the square; in atomic chess never next to the other king.
+func (g *generator) attacked(s Square) bool {
+	if g.atomic && g.b.nextToKing(s, -g.color) {
+		return false
+	}
+	checks, _ := g.attackers(s)
+	return checks > 0
+}
+
+func (g *generator) enpassantLegal(from Square, to Square) bool {
+	b := g.b
+	pawn := b.Grid[from.x][from.y]
+	captured := b.Grid[to.x][from.y]
+	b.Grid[from.x][from.y] = nil
+	b.Grid[to.x][from.y] = nil
+	b.Grid[to.x][to.y] = pawn
+	checks, _ := b.attacksOn(g.king, -g.color)
+	b.Grid[to.x][to.y] = nil
+	b.Grid[to.x][from.y] = captured
+	b.Grid[from.x][from.y] = pawn
+	return checks == 0
+}
+
+func (g *generator) step(from Square, v Vector) {
+	to := Square{x: from.x + v.x, y: from.y + v.y}
+	if !g.b.Contains(&to) {
+		return
+	}
+	p := g.b.Grid[to.x][to.y]
+	if p == nil {
+		g.add(from, to, 0, false)
+	} else if p.Color() != g.color {
+		g.add(from, to, FlagCapture, false)
+	}
+}
+
+func (g *generator) slide(from Square, v Vector) {
+	to := from
+	for {
+		to.x += v.x
+		to.y += v.y
+		if !g.b.Contains(&to) {
+			return
+		}
+		p := g.b.Grid[to.x][to.y]
+		if p == nil {
+			g.add(from, to, 0, false)
+			continue
+		}
+		if p.Color() != g.color {
+			g.add(from, to, FlagCapture, false)
+		}
+		return
+	}
+}
+
+func (g *generator) fairyMoves(from Square, def *PieceDef) {
+	targets := def.targets(g.b, from, g.color, g.targets[:0])
+	for i := 0; i < len(targets); i++ {
+		var flags MoveFlag
+		if targets[i].capture {
+			flags = FlagCapture
+		}
+		g.add(from, targets[i].to, flags, false)
+	}
+}
+
+func (g *generator) pawnMoves(from Square) {
+	b := g.b
+	dir := int8(g.color)
+	home, last := int8(0), b.Ranks()-1
+	if g.color == Black {
+		home, last = b.Ranks()-1, 0
+	}
+	to := Square{x: from.x, y: from.y + dir}
+	if b.Contains(&to) && b.Grid[to.x][to.y] == nil {
+		g.add(from, to, 0, to.y == last)
+		two := Square{x: from.x, y: to.y + dir}
+		// pawns on the first rank, as in horde, may double-step too
+		if (from.y == home+dir || from.y == home) && b.Rules().DoublePush && b.Grid[two.x][two.y] == nil {
+			g.add(from, two, FlagDoublePush, false)
+		}
+	}
+	for _, dx := range [2]int8{-1, 1} {
+		to := Square{x: from.x + dx, y: from.y + dir}
+		if !g.b.Contains(&to) {
+			continue
+		}
+		if ep := b.EnpassantSquare; ep != nil && *ep == to {
+			g.add(from, to, FlagCapture|FlagEnpassant, false)
+			continue
+		}
+		if p := b.Grid[to.x][to.y]; p != nil && p.Color() != g.color {
+			g.add(from, to, FlagCapture, to.y == last)
+		}
+	}
+}
+
+// castling follows King.ShortCastle and King.LongCastle: neither piece has
+// moved, the squares between are empty and the king does not pass through
+// an attacked square.
+func (g *generator) castling(k *King) {
+	b := g.b
+	if k.moveCounter > 0 || g.checks > 0 || !b.Rules().Castling {
+		return
+	}
+	x, y := g.king.x, g.king.y
+	corner := b.Files() - 1
+	sides := [2]struct {
+		rook  int8
+		empty [2]int8
+		safe  [2]int8
+		to    int8
+		flag  MoveFlag
+	}{
+		{corner, [2]int8{x + 1, corner}, [2]int8{x + 1, corner}, corner - 1, FlagShortCastle},
+		{0, [2]int8{1, x}, [2]int8{2, x}, 2, FlagLongCastle},
+	}
+	for i := 0; i < len(sides); i++ {
+		side := sides[i]
+		rook, ok := b.Grid[side.rook][y].(*Rook)
+		if !ok || rook.moveCounter > 0 {
+			continue
+		}
+		free := true
+		for x := side.empty[0]; x < side.empty[1]; x++ {
+			if b.Grid[x][y] != nil {
+				free = false
+			}
+		}
+		for x := side.safe[0]; x < side.safe[1] && free; x++ {
+			if g.attacked(Square{x: x, y: y}) {
+				free = false
+			}
+		}
+		if free {
+			g.add(g.king, Square{x: side.to, y: y}, side.flag, false)
+		}
+	}
+}
+
+// toMove turns a generated move into the Move the rest of the program plays.
+func (b *Board) toMove(gm GenMove) *Move {
+	m := &Move{}
+	b.setMove(m, gm)
+	return m
+}
+
+// setMove fills m with the generated move. Its squares are shared, see
+// squareAt.
+func (b *Board) setMove(m *Move, gm GenMove) {
+	from, to := gm.From, gm.To
+	if gm.Drop != 0 {
+		*m = *b.dropMove(gm.Drop, squareAt(to.x, to.y))
+		return
+	}
+	p := b.Grid[from.x][from.y]
+	*m = Move{
+		Piece:       p,
+		Start:       squareAt(from.x, from.y),
+		End:         squareAt(to.x, to.y),
+		ShortCastle: gm.Flags&FlagShortCastle != 0,
+		LongCastle:  gm.Flags&FlagLongCastle != 0,
+	}
+	if gm.Flags&FlagEnpassant != 0 {
+		m.CapturedPiece = b.Grid[to.x][from.y]
+	} else if gm.Flags&FlagCapture != 0 {
+		m.CapturedPiece = b.Grid[to.x][to.y]
+	}
+	if gm.Flags&FlagDoublePush != 0 {
+		m.EnpassantSquareAdded = squareAt(from.x, (from.y+to.y)/2)
+	}
+	if gm.Promote != 0 {
+		letter := gm.Promote
+		if p.Color() == White {
+			letter &^= 0x20
+		}
+		m.PromoteTo = newPiece(letter, m.End, b)
+		m.PromoteTo.base().promoted = true
+	}
+}
+
+// generatedMoves is GenerateMoves for callers that want Moves. The Moves
+// share one allocation.
+func (b *Board) generatedMoves(color Color) []*Move {
+	var list MoveList
+	b.GenerateMoves(color, &list)
+	values := make([]Move, list.n)
+	moves := make([]*Move, list.n)
+	for i := 0; i < list.n; i++ {
+		b.setMove(&values[i], list.moves[i])
+		moves[i] = &values[i]
+	}
+	return moves
+}
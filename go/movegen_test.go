@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+// suiteGames sets up the perft suite positions to generate moves in.
+func suiteGames(b *testing.B) []*Game {
+	positions := perftSuite()
+	games := []*Game{}
+	for i := 0; i < len(positions); i++ {
+		g, err := ParseFENRules(positions[i].FEN, positions[i].Rules)
+		if err != nil {
+			b.Fatal(err)
+		}
+		games = append(games, g)
+	}
+	return games
+}
+
+func BenchmarkGenerateMoves(b *testing.B) {
+	games := suiteGames(b)
+	var list MoveList
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i := 0; i < len(games); i++ {
+			games[i].Board.GenerateMoves(games[i].OnTurn, &list)
+		}
+	}
+}
+
+// BenchmarkPossibleMoves adds turning the moves into Moves for the callers
+// that play them.
+func BenchmarkPossibleMoves(b *testing.B) {
+	games := suiteGames(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i := 0; i < len(games); i++ {
+			games[i].Board.possibleMoves(games[i].OnTurn)
+		}
+	}
+}
+
+func BenchmarkFilteredMoves(b *testing.B) {
+	games := suiteGames(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i := 0; i < len(games); i++ {
+			games[i].Board.filteredMoves(games[i].OnTurn)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SAN returns the move in standard algebraic notation. The move has to be
+// legal in the current position.
+func (g *Game) SAN(m *Move) string {
+	san := g.sanWithoutCheck(m)
+	g.doMove(m)
+	if g.Board.inCheck(g.OnTurn) {
+		if g.isCheckmate() {
+			san += "#"
+		} else {
+			san += "+"
+		}
+	}
+	g.undoMove(m)
+	return san
+}
+
+func (g *Game) sanWithoutCheck(m *Move) string {
+	if m.ShortCastle {
+		return "O-O"
+	}
+	if m.LongCastle {
+		return "O-O-O"
+	}
+	if m.Drop {
+		return m.UCI()
+	}
+
+	san := ""
+	if _, ok := m.Piece.(*Pawn); ok {
+		if m.CapturedPiece != nil {
+			san += m.Start.String()[:1]
+		}
+	} else {
+		san += string(m.Piece.Letter() &^ 0x20)
+		san += g.disambiguation(m)
+	}
+	if m.CapturedPiece != nil {
+		san += "x"
+	}
+	san += m.End.String()
+	if m.PromoteTo != nil {
+		san += "=" + string(m.PromoteTo.Letter()&^0x20)
+	}
+	return san
+}
+
+func (g *Game) disambiguation(m *Move) string {
+	moves := g.possibleMoves()
+	ambiguous := false
+	sameFile := false
+	sameRank := false
+	for i := 0; i < len(moves); i++ {
+		other := moves[i]
+		if other.Piece == m.Piece || other.Drop || *other.End != *m.End || other.Piece.Letter() != m.Piece.Letter() {
+			continue
+		}
+		ambiguous = true
+		if other.Start.x == m.Start.x {
+			sameFile = true
+		}
+		if other.Start.y == m.Start.y {
+			sameRank = true
+		}
+	}
+	if !ambiguous {
+		return ""
+	}
+	if !sameFile {
+		return m.Start.String()[:1]
+	}
+	if !sameRank {
+		return m.Start.String()[1:]
+	}
+	return m.Start.String()
+}
+
+// ParseMove finds the legal move written either in UCI (e2e4, e7e8q) or in
+// SAN (e4, Nxf3+, O-O, e8=Q).
+func (g *Game) ParseMove(s string) (*Move, error) {
+	s = strings.TrimSpace(s)
+	moves := g.possibleMoves()
+	for i := 0; i < len(moves); i++ {
+		if moves[i].UCI() == s {
+			return moves[i], nil
+		}
+	}
+	san := normalizeSAN(s)
+	for i := 0; i < len(moves); i++ {
+		if g.sanWithoutCheck(moves[i]) == san {
+			return moves[i], nil
+		}
+	}
+	return nil, fmt.Errorf("illegal move %q", s)
+}
+
+func normalizeSAN(s string) string {
+	s = strings.TrimRight(s, "+#!?")
+	s = strings.ReplaceAll(s, "0", "O")
+	// pawn drops may leave out the letter
+	if strings.HasPrefix(s, "@") {
+		s = "P" + s
+	}
+	if len(s) > 2 && s[len(s)-2] != '=' && isPromotionLetter(s[len(s)-1]) && s[0] >= 'a' && s[0] <= 'j' {
+		s = s[:len(s)-1] + "=" + s[len(s)-1:]
+	}
+	return s
+}
+
+// sanHistory rewinds the game to its starting position and replays it,
+// returning the moves played so far in SAN.
+func (g *Game) sanHistory() []string {
+	moves := make([]*Move, len(g.Moves))
+	copy(moves, g.Moves)
+	for i := len(moves) - 1; i >= 0; i-- {
+		g.undoMove(moves[i])
+	}
+	sans := make([]string, len(moves))
+	for i := 0; i < len(moves); i++ {
+		sans[i] = g.SAN(moves[i])
+		g.doMove(moves[i])
+	}
+	return sans
+}
+
+// PGN exports the game with the seven tag roster, extra tags override the
+// defaults.
+func (g *Game) PGN(tags map[string]string) string {
+	return g.pgnWithMovetext(tags, g.movetext())
+}
+
+// pgnWithMovetext writes the tags of the game followed by the movetext,
+// which may carry comments and variations.
+func (g *Game) pgnWithMovetext(tags map[string]string, movetext string) string {
+	result := g.Result()
+	roster := [][2]string{
+		{"Event", "?"},
+		{"Site", "?"},
+		{"Date", time.Now().Format("2006.01.02")},
+		{"Round", "?"},
+		{"White", "?"},
+		{"Black", "?"},
+		{"Result", string(result)},
+	}
+	var sb strings.Builder
+	for i := 0; i < len(roster); i++ {
+		value := roster[i][1]
+		if v, ok := tags[roster[i][0]]; ok {
+			value = v
+		}
+		fmt.Fprintf(&sb, "[%s \"%s\"]\n", roster[i][0], escapeTag(value))
+	}
+	if rules := g.Board.Rules(); rules != Standard {
+		fmt.Fprintf(&sb, "[Variant \"%s\"]\n", rules.Name)
+	}
+	if g.StartFEN != "" {
+		fmt.Fprintf(&sb, "[SetUp \"1\"]\n[FEN \"%s\"]\n", g.StartFEN)
+	}
+	keys := []string{}
+	for k := range tags {
+		isRoster := false
+		for i := 0; i < len(roster); i++ {
+			if roster[i][0] == k {
+				isRoster = true
+			}
+		}
+		if !isRoster {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for i := 0; i < len(keys); i++ {
+		fmt.Fprintf(&sb, "[%s \"%s\"]\n", keys[i], escapeTag(tags[keys[i]]))
+	}
+	sb.WriteString("\n")
+	sb.WriteString(wrapText(movetext+" "+string(result), 79))
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+func (g *Game) movetext() string {
+	sans := g.sanHistory()
+	moveNumber := g.FullmoveNumber
+	blackToMove := g.OnTurn == Black
+	// walk back to the number of the first move
+	for i := 0; i < len(sans); i++ {
+		blackToMove = !blackToMove
+		if blackToMove {
+			moveNumber--
+		}
+	}
+	tokens := []string{}
+	for i := 0; i < len(sans); i++ {
+		if !blackToMove {
+			tokens = append(tokens, fmt.Sprintf("%d.", moveNumber))
+		} else if i == 0 {
+			tokens = append(tokens, fmt.Sprintf("%d...", moveNumber))
+		}
+		tokens = append(tokens, sans[i])
+		if blackToMove {
+			moveNumber++
+		}
+		blackToMove = !blackToMove
+	}
+	return strings.Join(tokens, " ")
+}
+
+func escapeTag(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	return strings.ReplaceAll(s, "\"", "\\\"")
+}
+
+func wrapText(text string, width int) string {
+	words := strings.Fields(text)
+	var sb strings.Builder
+	line := 0
+	for i := 0; i < len(words); i++ {
+		if line > 0 && line+1+len(words[i]) > width {
+			sb.WriteString("\n")
+			line = 0
+		} else if line > 0 {
+			sb.WriteString(" ")
+			line++
+		}
+		sb.WriteString(words[i])
+		line += len(words[i])
+	}
+	return sb.String()
+}
+
+// isPromotionLetter tells whether the uppercase letter names a piece a pawn
+// may become, fairy pieces and the antichess king included.
+func isPromotionLetter(c byte) bool {
+	if strings.IndexByte("KQRBN", c) >= 0 {
+		return true
+	}
+	_, ok := PieceDefs[c|0x20]
+	return c >= 'A' && c <= 'Z' && ok
+}
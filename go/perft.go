@@ -0,0 +1,274 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// perftPositions are the usual move generator test positions with their
+// known node counts per depth.
+var perftPositions = []struct {
+	FEN   string
+	Nodes []int
+}{
+	{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", []int{20, 400, 8902, 197281}},
+	{"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", []int{48, 2039, 97862}},
+	{"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1", []int{14, 191, 2812, 43238}},
+	{"r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1", []int{6, 264, 9467}},
+	{"rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8", []int{44, 1486, 62379}},
+	{"r4rk1/1pp1qppp/p1np1n2/2b1p1B1/2B1P1b1/P1NP1N2/1PP1QPPP/R4RK1 w - - 0 10", []int{46, 2079, 89890}},
+	{"rnabqkbcnr/pppppppppp/10/10/10/10/PPPPPPPPPP/RNABQKBCNR w KQkq - 0 1", []int{28, 784, 25228, 805128}},
+	{Crazyhouse.StartFEN, []int{20, 400, 8902, 197281}},
+	{ThreeCheck.StartFEN, []int{20, 400, 8902, 197281}},
+}
+
+type perftPosition struct {
+	Rules *RuleSet
+	FEN   string
+	Nodes []int
+}
+
+// variantPerftPositions are played by rules the FEN does not tell.
+var variantPerftPositions = []perftPosition{
+	{Atomic, StartFEN, []int{20, 400, 8902, 197326}},
+	{KingOfTheHill, StartFEN, []int{20, 400, 8902, 197281}},
+	{RacingKings, RacingKings.StartFEN, []int{21, 421, 11264, 296242}},
+	{Antichess, Antichess.StartFEN, []int{20, 400, 8067, 153299}},
+	{Horde, Horde.StartFEN, []int{8, 128, 1274, 23310}},
+}
+
+// parityPositions have no published counts; the generator is only checked
+// against playing every candidate there.
+var parityPositions = []perftPosition{
+	// en passant would uncover the rook along the rank
+	{nil, "8/8/8/K2pP2r/8/8/8/7k w - d6 0 1", nil},
+	// en passant takes the checking pawn
+	{nil, "8/8/8/2k5/3Pp3/8/8/4K3 b - d3 0 1", nil},
+	{nil, LosAlamos.StartFEN, nil},
+	{nil, Gardner.StartFEN, nil},
+	// castling three files over on the wide board
+	{nil, "r4k3r/10/10/10/10/10/10/R4K3R w KQkq - 0 1", nil},
+	// fairy leapers, riders and hoppers
+	{nil, "r1g1k1zr/ppp2ppp/2l5/3pi3/3P4/2L2M2/PPP1GPPP/R3K1ZR w KQkq - 0 1", nil},
+	// grasshoppers checking over a hurdle
+	{nil, "4k3/1g6/8/2G1g3/8/4G3/8/4K3 w - - 0 1", nil},
+	{nil, "r1bqk2r/pppp1ppp/2n1p3/4P3/1b1Pn3/2NB1N2/PPP2PPP/R1BQK2R[] b KQkq - 0 1", nil},
+	// drops cannot block a contact check
+	{nil, "4k3/8/8/8/8/8/3q4/R3K2R[Pr] w KQ - 0 1", nil},
+	// a promoted queen goes back to the pocket as a pawn
+	{nil, "4k3/8/8/8/8/8/1q6/Q~3K3[] b - - 0 1", nil},
+	// no kings, which pawns may promote to
+	{Antichess, "8/1P6/8/8/8/8/6p1/8 w - - 0 1", nil},
+}
+
+// perftSuite lists every position with known counts.
+func perftSuite() []perftPosition {
+	positions := []perftPosition{}
+	for i := 0; i < len(perftPositions); i++ {
+		positions = append(positions, perftPosition{FEN: perftPositions[i].FEN, Nodes: perftPositions[i].Nodes})
+	}
+	return append(positions, variantPerftPositions...)
+}
+
+type moveGenerator func(b *Board, color Color) []*Move
+
+// Perft counts the leaf nodes of the move tree to the depth.
+func Perft(g *Game, depth int, gen moveGenerator) int {
+	if depth == 0 {
+		return 1
+	}
+	moves := gen(g.Board, g.OnTurn)
+	if depth == 1 {
+		return len(moves)
+	}
+	nodes := 0
+	for i := 0; i < len(moves); i++ {
+		g.doMove(moves[i])
+		nodes += Perft(g, depth-1, gen)
+		g.undoMove(moves[i])
+	}
+	return nodes
+}
+
+// Divide returns the perft count below every root move, keyed by UCI.
+func Divide(g *Game, depth int, gen moveGenerator) map[string]int {
+	counts := map[string]int{}
+	moves := gen(g.Board, g.OnTurn)
+	for i := 0; i < len(moves); i++ {
+		g.doMove(moves[i])
+		counts[moves[i].UCI()] = Perft(g, depth-1, gen)
+		g.undoMove(moves[i])
+	}
+	return counts
+}
+
+// PerftList is Perft on GenerateMoves with one move list per ply, so the
+// generation itself does not allocate.
+func PerftList(g *Game, depth int, lists []MoveList) int {
+	if depth == 0 {
+		return 1
+	}
+	list := &lists[depth-1]
+	g.Board.GenerateMoves(g.OnTurn, list)
+	if depth == 1 {
+		return list.Len()
+	}
+	nodes := 0
+	for i := 0; i < list.Len(); i++ {
+		m := g.Board.toMove(list.At(i))
+		g.doMove(m)
+		nodes += PerftList(g, depth-1, lists)
+		g.undoMove(m)
+	}
+	return nodes
+}
+
+// compareGenerators walks the tree with the generator and filteredMoves
+// and returns the first position where they disagree, with the moves only
+// one of them produced.
+func compareGenerators(g *Game, depth int, gen moveGenerator) (string, []string, []string) {
+	fast := Divide(g, 1, gen)
+	slow := Divide(g, 1, (*Board).filteredMoves)
+	extra, missing := []string{}, []string{}
+	for m := range fast {
+		if _, ok := slow[m]; !ok {
+			extra = append(extra, m)
+		}
+	}
+	for m := range slow {
+		if _, ok := fast[m]; !ok {
+			missing = append(missing, m)
+		}
+	}
+	if len(extra) > 0 || len(missing) > 0 {
+		sort.Strings(extra)
+		sort.Strings(missing)
+		return g.FEN(), extra, missing
+	}
+	if depth <= 1 {
+		return "", nil, nil
+	}
+	moves := gen(g.Board, g.OnTurn)
+	for i := 0; i < len(moves); i++ {
+		g.doMove(moves[i])
+		fen, extra, missing := compareGenerators(g, depth-1, gen)
+		g.undoMove(moves[i])
+		if fen != "" {
+			return fen, extra, missing
+		}
+	}
+	return "", nil, nil
+}
+
+func perftCommand(args []string) {
+	flags := flag.NewFlagSet("perft", flag.ExitOnError)
+	fen := flags.String("fen", "", "position, the starting position by default")
+	variant := flags.String("variant", "", "rules, inferred from the position by default")
+	depth := flags.Int("depth", 3, "depth")
+	divide := flags.Bool("divide", false, "print the count below every move")
+	compare := flags.Bool("compare", false, "check the legal generator against playing every candidate")
+	suite := flags.Bool("suite", false, "run the built-in positions with known counts up to the depth, with -compare the rest too")
+	flags.Parse(args)
+
+	if *suite {
+		positions := perftSuite()
+		failed := 0
+		for i := 0; i < len(positions); i++ {
+			pos := positions[i]
+			g, err := ParseFENRules(pos.FEN, pos.Rules)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for d := 1; d <= *depth && d <= len(pos.Nodes); d++ {
+				nodes := Perft(g, d, (*Board).possibleMoves)
+				status := "ok"
+				if nodes != pos.Nodes[d-1] {
+					status = fmt.Sprintf("FAIL, expected %d", pos.Nodes[d-1])
+					failed++
+				}
+				if *compare {
+					if slow := Perft(g, d, (*Board).filteredMoves); slow != nodes {
+						status += fmt.Sprintf(", filtered generator %d", slow)
+						failed++
+					}
+				}
+				name := pos.FEN
+				if pos.Rules != nil {
+					name = pos.Rules.Name + " " + name
+				}
+				fmt.Printf("%s depth %d: %d %s\n", name, d, nodes, status)
+			}
+		}
+		for i := 0; *compare && i < len(parityPositions); i++ {
+			pos := parityPositions[i]
+			g, err := ParseFENRules(pos.FEN, pos.Rules)
+			if err != nil {
+				log.Fatal(err)
+			}
+			status := "ok"
+			if at, _, _ := compareGenerators(g, *depth, (*Board).possibleMoves); at != "" {
+				status = "FAIL, generators disagree at " + at
+				failed++
+			}
+			name := pos.FEN
+			if pos.Rules != nil {
+				name = pos.Rules.Name + " " + name
+			}
+			fmt.Printf("%s depth %d: %s\n", name, *depth, status)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	var rules *RuleSet
+	if *variant != "" {
+		r, err := RuleSetByName(*variant)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rules = r
+	}
+	g := InitGame()
+	if *fen != "" {
+		parsed, err := ParseFENRules(*fen, rules)
+		if err != nil {
+			log.Fatal(err)
+		}
+		g = parsed
+	} else if rules != nil {
+		g = NewGame(rules)
+	}
+	if *compare {
+		at, extra, missing := compareGenerators(g, *depth, (*Board).possibleMoves)
+		if at == "" {
+			fmt.Println("generators agree")
+			return
+		}
+		fmt.Printf("generators disagree at %s\n  only GenerateMoves: %v\n  only filtered generator: %v\n", at, extra, missing)
+		os.Exit(1)
+	}
+	start := time.Now()
+	if *divide {
+		counts := Divide(g, *depth, (*Board).possibleMoves)
+		moves := []string{}
+		for m := range counts {
+			moves = append(moves, m)
+		}
+		sort.Strings(moves)
+		total := 0
+		for i := 0; i < len(moves); i++ {
+			fmt.Printf("%s: %d\n", moves[i], counts[moves[i]])
+			total += counts[moves[i]]
+		}
+		fmt.Printf("nodes %d\n", total)
+	} else {
+		fmt.Printf("nodes %d\n", PerftList(g, *depth, make([]MoveList, *depth)))
+	}
+	fmt.Printf("time %v\n", time.Since(start).Round(time.Millisecond))
+}
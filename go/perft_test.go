@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+// TestGeneratorMatchesFiltered walks the suite and the parity positions,
+// the en passant discovered checks and the other board sizes among them,
+// and checks GenerateMoves against playing every candidate.
+func TestGeneratorMatchesFiltered(t *testing.T) {
+	positions := append(perftSuite(), parityPositions...)
+	for i := 0; i < len(positions); i++ {
+		pos := positions[i]
+		g, err := ParseFENRules(pos.FEN, pos.Rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		at, extra, missing := compareGenerators(g, 3, (*Board).possibleMoves)
+		if at != "" {
+			t.Errorf("%s: GenerateMoves disagrees at %s\n  only GenerateMoves: %v\n  only filteredMoves: %v", pos.FEN, at, extra, missing)
+		}
+	}
+}
+
+// TestPerftSuite counts every suite position, the 10x8 board among them,
+// to the depths that stay quick.
+func TestPerftSuite(t *testing.T) {
+	positions := perftSuite()
+	for i := 0; i < len(positions); i++ {
+		pos := positions[i]
+		g, err := ParseFENRules(pos.FEN, pos.Rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for d := 1; d <= len(pos.Nodes) && pos.Nodes[d-1] <= 100000; d++ {
+			if nodes := Perft(g, d, (*Board).possibleMoves); nodes != pos.Nodes[d-1] {
+				t.Errorf("%s: depth %d counts %d, want %d", pos.FEN, d, nodes, pos.Nodes[d-1])
+			}
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+type PGNGame struct {
+	Tags   map[string]string
+	Moves  []string
+	Result Result
+}
+
+// ParsePGN reads all games of a PGN file. Comments, variations and NAGs are
+// skipped, moves are kept as written.
+func ParsePGN(r io.Reader) ([]*PGNGame, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text := string(data)
+	games := []*PGNGame{}
+	var current *PGNGame
+	start := func() {
+		if current == nil {
+			current = &PGNGame{Tags: map[string]string{}, Result: Ongoing}
+		}
+	}
+	finish := func() {
+		if current != nil {
+			games = append(games, current)
+			current = nil
+		}
+	}
+
+	i := 0
+	lineStart := true
+	for i < len(text) {
+		c := text[i]
+		switch {
+		case c == '\n':
+			lineStart = true
+			i++
+			continue
+		case c == '%' && lineStart:
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+			continue
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+			continue
+		}
+		lineStart = false
+
+		switch {
+		case c == '[':
+			if current != nil && len(current.Moves) > 0 {
+				finish()
+			}
+			start()
+			end := tagEnd(text[i:])
+			if end < 0 {
+				return nil, fmt.Errorf("pgn: unterminated tag")
+			}
+			name, value, err := parseTag(text[i+1 : i+end])
+			if err != nil {
+				return nil, err
+			}
+			current.Tags[name] = value
+			i += end + 1
+		case c == '{':
+			end := strings.IndexByte(text[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("pgn: unterminated comment")
+			}
+			i += end + 1
+		case c == ';':
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		case c == '(':
+			depth := 0
+			for ; i < len(text); i++ {
+				if text[i] == '{' {
+					end := strings.IndexByte(text[i:], '}')
+					if end < 0 {
+						return nil, fmt.Errorf("pgn: unterminated comment")
+					}
+					i += end
+					continue
+				}
+				if text[i] == '(' {
+					depth++
+				}
+				if text[i] == ')' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			if depth != 0 {
+				return nil, fmt.Errorf("pgn: unterminated variation")
+			}
+			i++
+		default:
+			j := i
+			for j < len(text) && strings.IndexByte(" \t\r\n{}()[];", text[j]) < 0 {
+				j++
+			}
+			token := text[i:j]
+			i = j
+			start()
+			switch token {
+			case "1-0", "0-1", "1/2-1/2", "*":
+				current.Result = Result(token)
+				finish()
+				continue
+			}
+			if token[0] == '$' {
+				continue
+			}
+			if strings.Trim(token, "0123456789.") == "" {
+				continue
+			}
+			if dot := strings.LastIndexByte(token, '.'); dot >= 0 && strings.Trim(token[:dot], "0123456789.") == "" {
+				token = token[dot+1:]
+			}
+			if token != "" {
+				current.Moves = append(current.Moves, token)
+			}
+		}
+	}
+	if current != nil && (len(current.Moves) > 0 || len(current.Tags) > 0) {
+		finish()
+	}
+	return games, nil
+}
+
+// tagEnd finds the bracket closing a tag, skipping those inside the value
+// such as a crazyhouse pocket.
+func tagEnd(s string) int {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ']':
+			if !quoted {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func parseTag(s string) (string, string, error) {
+	s = strings.TrimSpace(s)
+	sp := strings.IndexAny(s, " \t")
+	if sp < 0 {
+		return "", "", fmt.Errorf("pgn: bad tag %q", s)
+	}
+	name := s[:sp]
+	rest := strings.TrimSpace(s[sp:])
+	if len(rest) < 2 || rest[0] != '"' || rest[len(rest)-1] != '"' {
+		return "", "", fmt.Errorf("pgn: bad tag value %q", rest)
+	}
+	rest = rest[1 : len(rest)-1]
+	var sb strings.Builder
+	for i := 0; i < len(rest); i++ {
+		if rest[i] == '\\' && i+1 < len(rest) {
+			i++
+		}
+		sb.WriteByte(rest[i])
+	}
+	return name, sb.String(), nil
+}
+
+// Replay sets up the starting position from the FEN or Variant tag, if any,
+// and plays the moves.
+func (pg *PGNGame) Replay() (*Game, error) {
+	g := InitGame()
+	var rules *RuleSet
+	if variant, ok := pg.Tags["Variant"]; ok {
+		r, err := RuleSetByName(variant)
+		if err != nil {
+			return nil, fmt.Errorf("pgn: %v", err)
+		}
+		rules = r
+		g = NewGame(rules)
+	}
+	if fen, ok := pg.Tags["FEN"]; ok {
+		parsed, err := ParseFENRules(fen, rules)
+		if err != nil {
+			return nil, err
+		}
+		g = parsed
+	}
+	for i := 0; i < len(pg.Moves); i++ {
+		m, err := g.ParseMove(pg.Moves[i])
+		if err != nil {
+			return nil, fmt.Errorf("pgn: move %d: %v", i+1, err)
+		}
+		g.doMove(m)
+	}
+	return g, nil
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// PolyglotKey hashes the position the way Polyglot opening books do.
+func (b *Board) PolyglotKey(onTurn Color) uint64 {
+	key := uint64(0)
+	pieces := b.getPieces()
+	for i := 0; i < len(pieces); i++ {
+		p := pieces[i]
+		kind := polyglotPieceKind(p)
+		if kind < 0 {
+			continue
+		}
+		sq := p.Square()
+		key ^= polyglotRandom[64*kind+8*int(sq.y)+int(sq.x)]
+	}
+
+	rights := b.castlingRights()
+	castling := "KQkq"
+	for i := 0; i < len(castling); i++ {
+		for j := 0; j < len(rights); j++ {
+			if rights[j] == castling[i] {
+				key ^= polyglotRandom[768+i]
+			}
+		}
+	}
+
+	// the en passant file only counts when a pawn can actually capture there
+	if ep := b.EnpassantSquare; ep != nil {
+		y := ep.y - int8(DirVectors[colorIndex(onTurn)][Up].y)
+		for _, dx := range []int8{-1, 1} {
+			sq := &Square{x: ep.x + dx, y: y}
+			if !sq.IsValid() {
+				continue
+			}
+			if pawn, ok := b.GetPiece(sq).(*Pawn); ok && pawn.Color() == onTurn {
+				key ^= polyglotRandom[772+int(ep.x)]
+				break
+			}
+		}
+	}
+
+	if onTurn == White {
+		key ^= polyglotRandom[780]
+	}
+	return key
+}
+
+func (g *Game) PolyglotKey() uint64 {
+	return g.Board.PolyglotKey(g.OnTurn)
+}
+
+// polyglotPieceKind orders pieces as black pawn, white pawn, black knight,
+// ... white king.
+func polyglotPieceKind(p Piece) int {
+	kinds := "pnbrqk"
+	letter := p.Letter() | 0x20
+	for i := 0; i < len(kinds); i++ {
+		if kinds[i] == letter {
+			if p.Color() == White {
+				return 2*i + 1
+			}
+			return 2 * i
+		}
+	}
+	return -1
+}
+
+type BookEntry struct {
+	Key    uint64
+	Move   uint16
+	Weight uint16
+	Learn  uint32
+}
+
+type Book struct {
+	Entries []BookEntry
+}
+
+func LoadBook(path string) (*Book, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadBook(f)
+}
+
+func ReadBook(r io.Reader) (*Book, error) {
+	book := &Book{}
+	br := bufio.NewReader(r)
+	buf := make([]byte, 16)
+	for {
+		_, err := io.ReadFull(br, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("book: %v", err)
+		}
+		book.Entries = append(book.Entries, BookEntry{
+			Key:    binary.BigEndian.Uint64(buf[0:8]),
+			Move:   binary.BigEndian.Uint16(buf[8:10]),
+			Weight: binary.BigEndian.Uint16(buf[10:12]),
+			Learn:  binary.BigEndian.Uint32(buf[12:16]),
+		})
+	}
+	if !sort.SliceIsSorted(book.Entries, func(i, j int) bool { return book.Entries[i].Key < book.Entries[j].Key }) {
+		return nil, errors.New("book: entries are not sorted by key")
+	}
+	return book, nil
+}
+
+func (book *Book) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, 16)
+	for i := 0; i < len(book.Entries); i++ {
+		e := book.Entries[i]
+		binary.BigEndian.PutUint64(buf[0:8], e.Key)
+		binary.BigEndian.PutUint16(buf[8:10], e.Move)
+		binary.BigEndian.PutUint16(buf[10:12], e.Weight)
+		binary.BigEndian.PutUint32(buf[12:16], e.Learn)
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Lookup returns the entries stored for the key.
+func (book *Book) Lookup(key uint64) []BookEntry {
+	i := sort.Search(len(book.Entries), func(i int) bool { return book.Entries[i].Key >= key })
+	j := i
+	for j < len(book.Entries) && book.Entries[j].Key == key {
+		j++
+	}
+	return book.Entries[i:j]
+}
+
+// Moves returns the legal book moves for the position with their weights.
+func (book *Book) Moves(g *Game) ([]*Move, []int) {
+	entries := book.Lookup(g.PolyglotKey())
+	moves := []*Move{}
+	weights := []int{}
+	for i := 0; i < len(entries); i++ {
+		m := g.polyglotMove(entries[i].Move)
+		if m == nil {
+			continue
+		}
+		moves = append(moves, m)
+		weights = append(weights, int(entries[i].Weight))
+	}
+	return moves, weights
+}
+
+// PickMove chooses a book move with probability proportional to its weight,
+// nil when the position is not in the book.
+func (book *Book) PickMove(g *Game) *Move {
+	moves, weights := book.Moves(g)
+	if len(moves) == 0 {
+		return nil
+	}
+	total := 0
+	for i := 0; i < len(weights); i++ {
+		total += weights[i]
+	}
+	if total == 0 {
+		return moves[rand.Intn(len(moves))]
+	}
+	r := rand.Intn(total)
+	for i := 0; i < len(moves); i++ {
+		r -= weights[i]
+		if r < 0 {
+			return moves[i]
+		}
+	}
+	return moves[len(moves)-1]
+}
+
+// polyglotMove decodes to-file, to-row, from-file, from-row and promotion
+// piece from the low bits up. Castling is written as the king capturing its
+// own rook.
+func (g *Game) polyglotMove(code uint16) *Move {
+	start := &Square{x: int8(code >> 6 & 7), y: int8(code >> 9 & 7)}
+	end := &Square{x: int8(code & 7), y: int8(code >> 3 & 7)}
+	promotion := []string{"", "n", "b", "r", "q"}
+	promo := int(code >> 12 & 7)
+	if promo >= len(promotion) {
+		return nil
+	}
+	if king, ok := g.Board.GetPiece(start).(*King); ok {
+		if rook, ok := g.Board.GetPiece(end).(*Rook); ok && rook.Color() == king.Color() {
+			if end.x > start.x {
+				end = &Square{x: 6, y: end.y}
+			} else {
+				end = &Square{x: 2, y: end.y}
+			}
+		}
+	}
+	uci := start.String() + end.String() + promotion[promo]
+	moves := g.possibleMoves()
+	for i := 0; i < len(moves); i++ {
+		if moves[i].UCI() == uci {
+			return moves[i]
+		}
+	}
+	return nil
+}
+
+func polyglotCode(m *Move) uint16 {
+	end := m.End
+	if m.ShortCastle {
+		end = &Square{x: 7, y: m.Start.y}
+	}
+	if m.LongCastle {
+		end = &Square{x: 0, y: m.Start.y}
+	}
+	code := uint16(end.x) | uint16(end.y)<<3 | uint16(m.Start.x)<<6 | uint16(m.Start.y)<<9
+	if m.PromoteTo != nil {
+		promo := map[byte]uint16{'n': 1, 'b': 2, 'r': 3, 'q': 4}[m.PromoteTo.Letter()|0x20]
+		code |= promo << 12
+	}
+	return code
+}
+
+// BuildBook collects the moves played in the first maxPly plies of the games.
+// A move needs at least minGames games to get in, its weight is two points
+// per win and one per draw of the side that played it.
+func BuildBook(games []*PGNGame, minGames int, maxPly int) (*Book, error) {
+	type stats struct {
+		games  int
+		points int
+	}
+	type bookMove struct {
+		key  uint64
+		code uint16
+	}
+	collected := map[bookMove]*stats{}
+	for i := 0; i < len(games); i++ {
+		pg := games[i]
+		g := InitGame()
+		if fen, ok := pg.Tags["FEN"]; ok {
+			parsed, err := ParseFEN(fen)
+			if err != nil {
+				return nil, fmt.Errorf("game %d: %v", i+1, err)
+			}
+			g = parsed
+		}
+		for ply := 0; ply < len(pg.Moves) && ply < maxPly; ply++ {
+			m, err := g.ParseMove(pg.Moves[ply])
+			if err != nil {
+				return nil, fmt.Errorf("game %d: %v", i+1, err)
+			}
+			bm := bookMove{key: g.PolyglotKey(), code: polyglotCode(m)}
+			s := collected[bm]
+			if s == nil {
+				s = &stats{}
+				collected[bm] = s
+			}
+			s.games++
+			switch {
+			case pg.Result == Draw:
+				s.points++
+			case pg.Result == WhiteWins && g.OnTurn == White, pg.Result == BlackWins && g.OnTurn == Black:
+				s.points += 2
+			}
+			g.doMove(m)
+		}
+	}
+
+	book := &Book{}
+	maxPoints := 0
+	for bm, s := range collected {
+		if s.games < minGames || s.points == 0 {
+			continue
+		}
+		if s.points > maxPoints {
+			maxPoints = s.points
+		}
+		book.Entries = append(book.Entries, BookEntry{Key: bm.key, Move: bm.code, Weight: uint16(min(s.points, 0xFFFF))})
+	}
+	if maxPoints > 0xFFFF {
+		for i := 0; i < len(book.Entries); i++ {
+			e := &book.Entries[i]
+			e.Weight = uint16(max(1, int(collected[bookMove{e.Key, e.Move}].points)*0xFFFF/maxPoints))
+		}
+	}
+	sort.Slice(book.Entries, func(i, j int) bool {
+		a, b := book.Entries[i], book.Entries[j]
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		if a.Weight != b.Weight {
+			return a.Weight > b.Weight
+		}
+		return a.Move < b.Move
+	})
+	return book, nil
+}
+
+func bookCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: chess book [build|probe]")
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "build":
+		flags := flag.NewFlagSet("book build", flag.ExitOnError)
+		pgnPath := flags.String("pgn", "", "PGN collection to build the book from")
+		out := flags.String("out", "book.bin", "book file to write")
+		minGames := flags.Int("min-games", 3, "minimum number of games for a move")
+		maxPly := flags.Int("max-ply", 20, "plies of each game to use")
+		flags.Parse(args[1:])
+
+		f, err := os.Open(*pgnPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		games, err := ParsePGN(f)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		book, err := BuildBook(games, *minGames, *maxPly)
+		if err != nil {
+			log.Fatal(err)
+		}
+		w, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := book.Write(w); err != nil {
+			log.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%d games, %d entries written to %s\n", len(games), len(book.Entries), *out)
+	case "probe":
+		flags := flag.NewFlagSet("book probe", flag.ExitOnError)
+		path := flags.String("book", "book.bin", "Polyglot book")
+		fen := flags.String("fen", StartFEN, "position to look up")
+		flags.Parse(args[1:])
+
+		book, err := LoadBook(*path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		g, err := ParseFEN(*fen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("key %016x\n", g.PolyglotKey())
+		moves, weights := book.Moves(g)
+		for i := 0; i < len(moves); i++ {
+			fmt.Printf("%-8s %5d\n", g.SAN(moves[i]), weights[i])
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown book command %q\n", args[0])
+		os.Exit(2)
+	}
+}
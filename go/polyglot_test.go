@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestPolyglotKey checks the keys given in the Polyglot book format
+// description, en passant squares that can and cannot be taken included.
+func TestPolyglotKey(t *testing.T) {
+	positions := []struct {
+		moves string
+		key   uint64
+	}{
+		{"", 0x463b96181691fc9c},
+		{"e2e4", 0x823c9b50fd114196},
+		{"e2e4 d7d5", 0x0756b94461c50fb0},
+		{"e2e4 d7d5 e4e5", 0x662fafb965db29d4},
+		{"e2e4 d7d5 e4e5 f7f5", 0x22a48b5a8e47ff78},
+		{"e2e4 d7d5 e4e5 f7f5 e1e2", 0x652a607ca3f242c1},
+		{"e2e4 d7d5 e4e5 f7f5 e1e2 e8f7", 0x00fdd303c946bdd9},
+		{"a2a4 b7b5 h2h4 b5b4 c2c4", 0x3c8123ea7b067637},
+		{"a2a4 b7b5 h2h4 b5b4 c2c4 b4c3 a1a3", 0x5c3f9b829b279560},
+	}
+	for i := 0; i < len(positions); i++ {
+		g := InitGame()
+		moves := strings.Fields(positions[i].moves)
+		for j := 0; j < len(moves); j++ {
+			m, err := g.ParseMove(moves[j])
+			if err != nil {
+				t.Fatalf("%s: %v", positions[i].moves, err)
+			}
+			g.doMove(m)
+		}
+		if key := g.PolyglotKey(); key != positions[i].key {
+			t.Errorf("after %q: key %016x, want %016x", positions[i].moves, key, positions[i].key)
+		}
+	}
+}
+
+func TestBookRoundTrip(t *testing.T) {
+	g, err := ParseFEN("r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// e1h1, the king taking its own rook
+	castle := uint16(7 | 4<<6)
+	book := &Book{Entries: []BookEntry{
+		// e2e4
+		{Key: InitGame().PolyglotKey(), Move: 0x31c, Weight: 3},
+		{Key: g.PolyglotKey(), Move: castle, Weight: 2, Learn: 7},
+	}}
+	sort.Slice(book.Entries, func(i, j int) bool { return book.Entries[i].Key < book.Entries[j].Key })
+	var buf bytes.Buffer
+	if err := book.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 16*len(book.Entries) {
+		t.Fatalf("wrote %d bytes for %d entries", buf.Len(), len(book.Entries))
+	}
+	read, err := ReadBook(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read.Entries, book.Entries) {
+		t.Fatalf("read back %+v, wrote %+v", read.Entries, book.Entries)
+	}
+
+	entries := read.Lookup(g.PolyglotKey())
+	if len(entries) != 1 {
+		t.Fatalf("%d entries for the castling position", len(entries))
+	}
+	m := g.polyglotMove(entries[0].Move)
+	if m == nil || !m.ShortCastle || g.SAN(m) != "O-O" {
+		t.Fatalf("e1h1 decodes to %v", m)
+	}
+	if code := polyglotCode(m); code != castle {
+		t.Errorf("O-O encodes as %#x, want %#x", code, castle)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+)
+
+// BoardBuilder sets up a position piece by piece, for odds games and study
+// positions. Its methods chain and Build reports the first mistake:
+//
+//	g, err := NewBoard().Place('K', "e1").Place('R', "a1").Place('k', "e8").Build()
+type BoardBuilder struct {
+	rules     *RuleSet
+	grid      [MaxSize][MaxSize]byte
+	onTurn    Color
+	castling  string
+	enpassant string
+	err       error
+}
+
+// NewBoard starts an empty standard board with White to move. Unless told
+// otherwise the position keeps every castling right its placement allows.
+func NewBoard() *BoardBuilder {
+	return &BoardBuilder{rules: Standard, onTurn: White}
+}
+
+func (bb *BoardBuilder) fail(format string, args ...interface{}) *BoardBuilder {
+	if bb.err == nil {
+		bb.err = fmt.Errorf("position: "+format, args...)
+	}
+	return bb
+}
+
+// Rules sets the rules the position is played by.
+func (bb *BoardBuilder) Rules(rules *RuleSet) *BoardBuilder {
+	bb.rules = rules
+	return bb
+}
+
+// Place puts the piece, given by its FEN letter, on the square in place of
+// whatever stood there.
+func (bb *BoardBuilder) Place(piece byte, square string) *BoardBuilder {
+	sq, err := ParseSquare(square)
+	if err != nil {
+		return bb.fail("%v", err)
+	}
+	if newPiece(piece, sq, nil) == nil {
+		return bb.fail("unknown piece %q", piece)
+	}
+	bb.grid[sq.x][sq.y] = piece
+	return bb
+}
+
+// Remove empties the square.
+func (bb *BoardBuilder) Remove(square string) *BoardBuilder {
+	sq, err := ParseSquare(square)
+	if err != nil {
+		return bb.fail("%v", err)
+	}
+	bb.grid[sq.x][sq.y] = 0
+	return bb
+}
+
+// Turn sets the side to move.
+func (bb *BoardBuilder) Turn(color Color) *BoardBuilder {
+	bb.onTurn = color
+	return bb
+}
+
+// Castling sets the castling rights as the FEN field does, "-" for none.
+func (bb *BoardBuilder) Castling(rights string) *BoardBuilder {
+	bb.castling = rights
+	return bb
+}
+
+// EnPassant sets the square the last double step passed over.
+func (bb *BoardBuilder) EnPassant(square string) *BoardBuilder {
+	bb.enpassant = square
+	return bb
+}
+
+// Build checks the position and returns a game starting from it: each side
+// needs its one king, pawns stay off the back ranks, the side not to move
+// may not be in check, and castling and en passant must fit the placement.
+func (bb *BoardBuilder) Build() (*Game, error) {
+	if bb.err != nil {
+		return nil, bb.err
+	}
+	rules := bb.rules
+	board := &Board{rules: rules}
+	pieces := []Piece{}
+	for x := int8(0); x < MaxSize; x++ {
+		for y := int8(0); y < MaxSize; y++ {
+			if bb.grid[x][y] == 0 {
+				continue
+			}
+			sq := &Square{x: x, y: y}
+			if !board.Contains(sq) {
+				return nil, fmt.Errorf("position: %s is off the %dx%d board", sq, rules.Files, rules.Ranks)
+			}
+			pieces = append(pieces, newPiece(bb.grid[x][y], sq, board))
+		}
+	}
+	board.setPieces(pieces)
+	if err := board.checkPlacement(); err != nil {
+		return nil, fmt.Errorf("position: %v", err)
+	}
+
+	game := &Game{Board: board, OnTurn: bb.onTurn, FullmoveNumber: 1}
+	castling := bb.castling
+	if castling == "" {
+		castling = "-"
+		if rules.Castling {
+			castling = board.castlingRights()
+		}
+	}
+	if err := board.setCastlingRights(castling); err != nil {
+		return nil, fmt.Errorf("position: %v", err)
+	}
+	if bb.enpassant != "" {
+		sq, err := ParseSquare(bb.enpassant)
+		if err != nil || !board.passedOver(sq, -game.OnTurn) {
+			return nil, fmt.Errorf("position: no double step passed over %s", bb.enpassant)
+		}
+		board.EnpassantSquare = sq
+	}
+	if board.inCheck(-game.OnTurn) {
+		return nil, fmt.Errorf("position: side not to move is in check")
+	}
+	game.StartFEN = game.FEN()
+	if game.StartFEN == StartFEN {
+		game.StartFEN = ""
+	}
+	return game, nil
+}
+
+// checkPlacement rejects pawns on the back ranks and sides without their
+// one king, as far as the rules ask for either.
+func (b *Board) checkPlacement() error {
+	rules := b.Rules()
+	pieces := b.getPieces()
+	for i := 0; i < len(pieces); i++ {
+		pawn, ok := pieces[i].(*Pawn)
+		y := pieces[i].Square().y
+		if ok && (y == 0 || y == b.Ranks()-1) && !(rules.FirstRankPawns && pawn.Row() == 0) {
+			return fmt.Errorf("pawn on rank %d", y+1)
+		}
+	}
+	if rules.Antichess {
+		// kings are ordinary pieces there
+		return nil
+	}
+	for _, c := range []Color{White, Black} {
+		kings := 0
+		for i := 0; i < len(pieces); i++ {
+			if _, ok := pieces[i].(*King); ok && pieces[i].Color() == c {
+				kings++
+			}
+		}
+		if kings != 1 && !(kings == 0 && c == rules.Kingless) {
+			return fmt.Errorf("expected one king per side")
+		}
+	}
+	return nil
+}
+
+// passedOver tells whether a pawn of the color can just have stepped two
+// squares over the square.
+func (b *Board) passedOver(sq *Square, color Color) bool {
+	from := &Square{x: sq.x, y: sq.y - int8(color)}
+	to := &Square{x: sq.x, y: sq.y + int8(color)}
+	if !b.Rules().DoublePush || !b.Contains(from) || !b.Contains(to) || b.GetPiece(sq) != nil || b.GetPiece(from) != nil {
+		return false
+	}
+	pawn, ok := b.GetPiece(to).(*Pawn)
+	if !ok || pawn.Color() != color {
+		return false
+	}
+	// the pawn left its second rank, or its first where pawns stand there
+	return pawn.Row() == 3 || pawn.Row() == 2 && b.Rules().FirstRankPawns
+}
+
+// Preset is a named starting position, the standard one or one with
+// material taken off to give odds.
+type Preset struct {
+	Name string
+	// Remove lists the squares emptied from the standard start.
+	Remove []string
+}
+
+// Presets for odds games. The stronger player gives the odds with White,
+// except at pawn and move, where they take Black without the f-pawn and
+// the weaker player moves first.
+var Presets = []*Preset{
+	{Name: "start"},
+	{Name: "queen odds", Remove: []string{"d1"}},
+	{Name: "rook odds", Remove: []string{"a1"}},
+	{Name: "knight odds", Remove: []string{"b1"}},
+	{Name: "pawn and move", Remove: []string{"f7"}},
+}
+
+// PresetByName finds a preset, ignoring case, spaces and hyphens.
+func PresetByName(name string) (*Preset, error) {
+	for i := 0; i < len(Presets); i++ {
+		if nameKey(Presets[i].Name) == nameKey(name) {
+			return Presets[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown preset %q", name)
+}
+
+// Builder returns the preset's position to build on.
+func (p *Preset) Builder() *BoardBuilder {
+	bb := NewBoard()
+	backRank := "RNBQKBNR"
+	for x := 0; x < len(backRank); x++ {
+		file := string(rune('a' + x))
+		bb.Place(backRank[x], file+"1").Place('P', file+"2")
+		bb.Place('p', file+"7").Place(backRank[x]|0x20, file+"8")
+	}
+	for i := 0; i < len(p.Remove); i++ {
+		bb.Remove(p.Remove[i])
+	}
+	return bb
+}
+
+// Game sets up a game from the preset.
+func (p *Preset) Game() (*Game, error) {
+	return p.Builder().Build()
+}
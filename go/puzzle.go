@@ -0,0 +1,484 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Puzzle is a position with a single winning line. Moves starts with the
+// solver's move and alternates with the forced replies.
+type Puzzle struct {
+	ID     string   `json:"id"`
+	FEN    string   `json:"fen"`
+	Moves  []string `json:"moves"`
+	SAN    []string `json:"san"`
+	Themes []string `json:"themes"`
+	Rating int      `json:"rating"`
+	Source string   `json:"source,omitempty"`
+}
+
+// PuzzleMiner looks for positions where exactly one move wins decisively:
+// it mates or gains at least Win centipawns while every other move stays
+// below that. The whole line must be unique: it runs until the mate, until
+// the gain shows in the evaluation after the reply, or for MaxMoves moves,
+// and a candidate where any later move has a winning alternative is
+// rejected. Only the final mate may be given in more than one way.
+type PuzzleMiner struct {
+	Depth    int
+	Win      int
+	MaxMoves int
+
+	seen map[string]bool
+}
+
+func NewPuzzleMiner() *PuzzleMiner {
+	return &PuzzleMiner{Depth: 2, Win: 300, MaxMoves: 3, seen: map[string]bool{}}
+}
+
+type scoredMove struct {
+	move  *Move
+	score int
+}
+
+// Find returns the puzzle in the current position, if there is one.
+func (pm *PuzzleMiner) Find(g *Game) *Puzzle {
+	key := g.positionKey()
+	if pm.seen[key] {
+		return nil
+	}
+	pm.seen[key] = true
+	if res := (&Engine{Depth: pm.Depth}).Search(g); res.Move == nil || res.Score < pm.Win {
+		return nil
+	}
+
+	p := &Puzzle{FEN: g.FEN()}
+	line := []*Move{}
+	themes := map[string]bool{}
+	mating := false
+	mated := false
+	ambiguous := false
+	start := Evaluate(g)
+	for len(line) < 2*pm.MaxMoves {
+		best, winners := pm.bestMoves(g)
+		if len(line) == 0 && best.score >= MateScore-100 {
+			mating = true
+		}
+		if winners == 0 || winners > 1 && (len(line) == 0 || best.score != MateScore-1) {
+			ambiguous = true
+			break
+		}
+		p.SAN = append(p.SAN, g.SAN(best.move))
+		line = append(line, best.move)
+		g.doMove(best.move)
+		tagMove(g, best.move, themes)
+		if g.isCheckmate() {
+			mated = true
+			break
+		}
+		reply := (&Engine{Depth: pm.Depth}).Search(g).Move
+		if reply == nil {
+			break
+		}
+		p.SAN = append(p.SAN, g.SAN(reply))
+		line = append(line, reply)
+		g.doMove(reply)
+		if !mating && Evaluate(g)-start >= pm.Win {
+			break
+		}
+	}
+	if len(line)%2 == 0 && len(line) > 0 {
+		g.undoMove(line[len(line)-1])
+		line = line[:len(line)-1]
+		p.SAN = p.SAN[:len(p.SAN)-1]
+	}
+	if mated {
+		themes["mate"] = true
+		themes[fmt.Sprintf("mateIn%d", (len(line)+1)/2)] = true
+		if backRankMate(g) {
+			themes["backRankMate"] = true
+		}
+	}
+	for i := len(line) - 1; i >= 0; i-- {
+		g.undoMove(line[i])
+	}
+	if len(line) == 0 || ambiguous || mating && !mated {
+		return nil
+	}
+
+	for i := 0; i < len(line); i++ {
+		p.Moves = append(p.Moves, line[i].UCI())
+	}
+	for _, theme := range []string{"mate", "mateIn1", "mateIn2", "mateIn3", "mateIn4", "mateIn5",
+		"backRankMate", "fork", "pin", "skewer", "discoveredAttack", "promotion"} {
+		if themes[theme] {
+			p.Themes = append(p.Themes, theme)
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	p.ID = fmt.Sprintf("%08x", h.Sum32())
+	p.Rating = puzzleRating(line)
+	return p
+}
+
+// bestMoves scores every legal move and returns the best one together with
+// the number of moves that win.
+func (pm *PuzzleMiner) bestMoves(g *Game) (scoredMove, int) {
+	e := &Engine{Depth: max(1, pm.Depth-1)}
+	moves := g.possibleMoves()
+	best := scoredMove{score: -MateScore - 1}
+	winners := 0
+	for i := 0; i < len(moves); i++ {
+		m := moves[i]
+		g.doMove(m)
+		score := MateScore - 1
+		if !g.isCheckmate() {
+			score = -analysisSearch(g, e).Score
+		}
+		g.undoMove(m)
+		if score >= pm.Win {
+			winners++
+		}
+		if score > best.score {
+			best = scoredMove{m, score}
+		}
+	}
+	return best, winners
+}
+
+// puzzleRating guesses the difficulty: longer lines and quiet first moves
+// are harder to find.
+func puzzleRating(line []*Move) int {
+	rating := 900 + 300*(len(line)/2)
+	first := line[0]
+	if first.CapturedPiece == nil && first.PromoteTo == nil {
+		rating += 200
+	}
+	return rating
+}
+
+func sliderDirections(p Piece) []DirectionName {
+	switch p.(type) {
+	case *Rook:
+		return []DirectionName{Up, Down, Left, Right}
+	case *Bishop:
+		return []DirectionName{UpLeft, UpRight, DownLeft, DownRight}
+	case *Queen:
+		return []DirectionName{Up, Down, Left, Right, UpLeft, UpRight, DownLeft, DownRight}
+	}
+	return nil
+}
+
+// rayPieces returns up to n pieces met walking from the square in the
+// direction.
+func rayPieces(b *Board, s *Square, d DirectionName, n int) []Piece {
+	pieces := []Piece{}
+	squares := s.Direction(b, White, d)
+	for i := 0; i < len(squares) && len(pieces) < n; i++ {
+		if p := b.GetPiece(squares[i]); p != nil {
+			pieces = append(pieces, p)
+		}
+	}
+	return pieces
+}
+
+// attacked returns the enemy pieces the piece can capture.
+func attacked(p Piece) []Piece {
+	targets := []Piece{}
+	moves := p.PossibleMoves()
+	for i := 0; i < len(moves); i++ {
+		if moves[i].CapturedPiece != nil && *moves[i].CapturedPiece.Square() == *moves[i].End {
+			targets = append(targets, moves[i].CapturedPiece)
+		}
+	}
+	return targets
+}
+
+func defended(p Piece) bool {
+	return p.Board().IsAttacked(p.Square(), -p.Color())
+}
+
+// valuable tells whether attacking the target with the attacker wins
+// something: the target is the king, worth more or hanging.
+func valuable(target Piece, attacker Piece) bool {
+	if _, ok := target.(*King); ok {
+		return true
+	}
+	return PieceValue(target) > PieceValue(attacker) || !defended(target)
+}
+
+// tagMove adds the tactical themes of a solver move that was just played.
+func tagMove(g *Game, m *Move, themes map[string]bool) {
+	b := g.Board
+	mover := b.GetPiece(m.End)
+	if m.PromoteTo != nil {
+		themes["promotion"] = true
+	}
+
+	targets := attacked(mover)
+	count := 0
+	for i := 0; i < len(targets); i++ {
+		if valuable(targets[i], mover) {
+			count++
+		}
+	}
+	if count >= 2 {
+		themes["fork"] = true
+	}
+
+	directions := sliderDirections(mover)
+	for i := 0; i < len(directions); i++ {
+		ray := rayPieces(b, m.End, directions[i], 2)
+		if len(ray) < 2 || ray[0].Color() == mover.Color() || ray[1].Color() == mover.Color() {
+			continue
+		}
+		_, frontKing := ray[0].(*King)
+		_, backKing := ray[1].(*King)
+		switch {
+		case backKing || !frontKing && PieceValue(ray[1]) >= 500 && PieceValue(ray[1]) > PieceValue(ray[0]):
+			themes["pin"] = true
+		case frontKing && PieceValue(ray[1]) > 100 || PieceValue(ray[0]) > PieceValue(ray[1]) && !defended(ray[1]):
+			themes["skewer"] = true
+		}
+	}
+
+	pieces := b.getPieces()
+	for i := 0; i < len(pieces); i++ {
+		p := pieces[i]
+		if p.Color() != mover.Color() || p == mover {
+			continue
+		}
+		directions := sliderDirections(p)
+		for j := 0; j < len(directions); j++ {
+			squares := p.Square().Direction(b, White, directions[j])
+			passed := false
+			for k := 0; k < len(squares); k++ {
+				if *squares[k] == *m.Start {
+					passed = true
+				}
+				target := b.GetPiece(squares[k])
+				if target == nil {
+					continue
+				}
+				if passed && target.Color() != p.Color() && valuable(target, p) {
+					themes["discoveredAttack"] = true
+				}
+				break
+			}
+		}
+	}
+}
+
+// backRankMate tells whether the side on turn is mated on its first rank by
+// a rook or queen along it.
+func backRankMate(g *Game) bool {
+	king := g.Board.getKing(g.OnTurn)
+	if king == nil || king.Row() != 0 {
+		return false
+	}
+	pieces := g.Board.getPieces()
+	for i := 0; i < len(pieces); i++ {
+		p := pieces[i]
+		if p.Color() == king.Color() || p.Square().y != king.Square().y {
+			continue
+		}
+		switch p.(type) {
+		case *Rook, *Queen:
+			targets := attacked(p)
+			for j := 0; j < len(targets); j++ {
+				if targets[j] == Piece(king) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// MineGame looks for puzzles in every position of a recorded game.
+func (pm *PuzzleMiner) MineGame(pg *PGNGame) ([]*Puzzle, error) {
+	g, err := pg.Replay()
+	if err != nil {
+		return nil, err
+	}
+	played := append([]*Move{}, g.Moves...)
+	for i := len(played) - 1; i >= 0; i-- {
+		g.undoMove(played[i])
+	}
+	source := fmt.Sprintf("%s - %s", pg.Tags["White"], pg.Tags["Black"])
+	puzzles := []*Puzzle{}
+	for i := 0; i <= len(played); i++ {
+		if p := pm.Find(g); p != nil {
+			p.Source = fmt.Sprintf("%s, ply %d", source, i)
+			puzzles = append(puzzles, p)
+		}
+		if i < len(played) {
+			g.doMove(played[i])
+		}
+	}
+	return puzzles, nil
+}
+
+// MineSelfPlay plays a random game the way the default command does and
+// looks for puzzles along the way.
+func (pm *PuzzleMiner) MineSelfPlay(n int) []*Puzzle {
+	g := InitGame()
+	puzzles := []*Puzzle{}
+	for ply := 0; !g.isOver(); ply++ {
+		if p := pm.Find(g); p != nil {
+			p.Source = fmt.Sprintf("self-play %d, ply %d", n, ply)
+			puzzles = append(puzzles, p)
+		}
+		g.doMove(randomMove(g))
+	}
+	return puzzles
+}
+
+func WritePuzzlesCSV(w io.Writer, puzzles []*Puzzle) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "fen", "moves", "san", "rating", "themes", "source"})
+	for i := 0; i < len(puzzles); i++ {
+		p := puzzles[i]
+		cw.Write([]string{p.ID, p.FEN, strings.Join(p.Moves, " "), strings.Join(p.SAN, " "),
+			strconv.Itoa(p.Rating), strings.Join(p.Themes, " "), p.Source})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func WritePuzzlesJSON(w io.Writer, puzzles []*Puzzle) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(puzzles)
+}
+
+func mineCommand(args []string) {
+	flags := flag.NewFlagSet("mine", flag.ExitOnError)
+	depth := flags.Int("depth", 2, "search depth used to score the moves")
+	win := flags.Int("win", 300, "centipawn gain that counts as decisive")
+	maxMoves := flags.Int("moves", 3, "longest solution in solver moves")
+	games := flags.Int("games", 10, "random self-play games to mine when no PGN is given")
+	out := flags.String("out", "", "output file, .json for JSON, CSV otherwise; standard output by default")
+	flags.Parse(args)
+
+	pm := NewPuzzleMiner()
+	pm.Depth = *depth
+	pm.Win = *win
+	pm.MaxMoves = *maxMoves
+	puzzles := []*Puzzle{}
+	if flags.NArg() == 0 {
+		rand.Seed(time.Now().UnixNano())
+		for i := 0; i < *games; i++ {
+			puzzles = append(puzzles, pm.MineSelfPlay(i+1)...)
+		}
+	}
+	for i := 0; i < flags.NArg(); i++ {
+		f, err := os.Open(flags.Arg(i))
+		if err != nil {
+			log.Fatal(err)
+		}
+		pgs, err := ParsePGN(f)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for j := 0; j < len(pgs); j++ {
+			found, err := pm.MineGame(pgs[j])
+			if err != nil {
+				log.Fatalf("%s: game %d: %v", flags.Arg(i), j+1, err)
+			}
+			puzzles = append(puzzles, found...)
+		}
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	var err error
+	if strings.HasSuffix(*out, ".json") {
+		err = WritePuzzlesJSON(w, puzzles)
+	} else {
+		err = WritePuzzlesCSV(w, puzzles)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "%d puzzles\n", len(puzzles))
+}
+
+// LoadPuzzles reads puzzles as written by the miner, JSON when the file name
+// ends in .json and CSV otherwise.
+func LoadPuzzles(path string) ([]*Puzzle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	puzzles := []*Puzzle{}
+	if strings.HasSuffix(path, ".json") {
+		err := json.NewDecoder(f).Decode(&puzzles)
+		return puzzles, err
+	}
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return puzzles, nil
+	}
+	columns := map[string]int{}
+	for i := 0; i < len(records[0]); i++ {
+		columns[records[0][i]] = i
+	}
+	if _, ok := columns["fen"]; !ok {
+		return nil, fmt.Errorf("%s: no fen column", path)
+	}
+	if _, ok := columns["moves"]; !ok {
+		return nil, fmt.Errorf("%s: no moves column", path)
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	for i := 1; i < len(records); i++ {
+		r := records[i]
+		p := &Puzzle{
+			ID:     field(r, "id"),
+			FEN:    field(r, "fen"),
+			Moves:  strings.Fields(field(r, "moves")),
+			SAN:    strings.Fields(field(r, "san")),
+			Themes: strings.Fields(field(r, "themes")),
+			Source: field(r, "source"),
+		}
+		if rating := field(r, "rating"); rating != "" {
+			p.Rating, err = strconv.Atoi(rating)
+			if err != nil {
+				return nil, fmt.Errorf("%s: line %d: bad rating %q", path, i+1, rating)
+			}
+		}
+		if p.ID == "" {
+			p.ID = strconv.Itoa(i)
+		}
+		puzzles = append(puzzles, p)
+	}
+	return puzzles, nil
+}
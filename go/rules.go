@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaxSize bounds the files and ranks of any board.
+const MaxSize int8 = 10
+
+// RuleSet describes a board and the rules that vary with it.
+type RuleSet struct {
+	Name     string
+	Files    int8
+	Ranks    int8
+	StartFEN string
+	// KingFile is where the king starts; castling needs it there.
+	KingFile   int8
+	Castling   bool
+	DoublePush bool
+	// Promotions are the lowercase letters pawns promote to.
+	Promotions string
+	// Drops lets captured pieces be put back on the board by the capturer.
+	Drops bool
+	// Atomic captures blow up everything around the target but pawns.
+	Atomic bool
+	// Variant decides the game besides checkmate and the draw rules.
+	Variant Variant
+	// Checks given win three-check; it is zero in other games.
+	Checks int
+	// NoChecks forbids moves that give check, as in racing kings.
+	NoChecks bool
+	// Antichess makes captures compulsory and the king an ordinary piece
+	// that may be taken and promoted to; there is no check.
+	Antichess bool
+	// Kingless is the side that plays without a king, if any.
+	Kingless Color
+	// FirstRankPawns lets pawns stand on their first rank, from where they
+	// may step one or two squares.
+	FirstRankPawns bool
+	// PartnerPockets sends captured pieces to the partner on the other
+	// board, as in bughouse, rather than to the capturer's pocket.
+	PartnerPockets bool
+}
+
+var Standard = &RuleSet{
+	Name:       "standard",
+	Files:      8,
+	Ranks:      8,
+	StartFEN:   StartFEN,
+	KingFile:   4,
+	Castling:   true,
+	DoublePush: true,
+	Promotions: "qrbn",
+}
+
+// LosAlamos is the 6x6 game without bishops, castling or the pawn's double
+// step.
+var LosAlamos = &RuleSet{
+	Name:       "losalamos",
+	Files:      6,
+	Ranks:      6,
+	StartFEN:   "rnqknr/pppppp/6/6/PPPPPP/RNQKNR w - - 0 1",
+	KingFile:   3,
+	Promotions: "qrn",
+}
+
+// Gardner is 5x5 minichess with every piece but no castling or double step.
+var Gardner = &RuleSet{
+	Name:       "gardner",
+	Files:      5,
+	Ranks:      5,
+	StartFEN:   "rnbqk/ppppp/5/PPPPP/RNBQK w - - 0 1",
+	KingFile:   4,
+	Promotions: "qrbn",
+}
+
+// Capablanca is played on 10x8 with an archbishop and a chancellor. The king
+// castles three files over, to the c or i file.
+var Capablanca = &RuleSet{
+	Name:       "capablanca",
+	Files:      10,
+	Ranks:      8,
+	StartFEN:   "rnabqkbcnr/pppppppppp/10/10/10/10/PPPPPPPPPP/RNABQKBCNR w KQkq - 0 1",
+	KingFile:   5,
+	Castling:   true,
+	DoublePush: true,
+	Promotions: "qcarbn",
+}
+
+// Crazyhouse is standard chess where captured pieces change sides and can
+// be dropped instead of moving.
+var Crazyhouse = &RuleSet{
+	Name:       "crazyhouse",
+	Files:      8,
+	Ranks:      8,
+	StartFEN:   "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR[] w KQkq - 0 1",
+	KingFile:   4,
+	Castling:   true,
+	DoublePush: true,
+	Promotions: "qrbn",
+	Drops:      true,
+}
+
+// Bughouse is crazyhouse on two boards, where a captured piece goes to the
+// capturer's partner; BughouseGame links the boards.
+var Bughouse = &RuleSet{
+	Name:           "bughouse",
+	Files:          8,
+	Ranks:          8,
+	StartFEN:       "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR[] w KQkq - 0 1",
+	KingFile:       4,
+	Castling:       true,
+	DoublePush:     true,
+	Promotions:     "qrbn",
+	Drops:          true,
+	PartnerPockets: true,
+}
+
+// Atomic is standard chess where captures explode. Kings cannot capture and
+// may stand next to each other whatever attacks them.
+var Atomic = &RuleSet{
+	Name:       "atomic",
+	Files:      8,
+	Ranks:      8,
+	StartFEN:   StartFEN,
+	KingFile:   4,
+	Castling:   true,
+	DoublePush: true,
+	Promotions: "qrbn",
+	Atomic:     true,
+	Variant:    atomicVariant{},
+}
+
+// ThreeCheck is standard chess also won by giving the third check. Its FEN
+// carries the checks each side has left.
+var ThreeCheck = &RuleSet{
+	Name:       "threecheck",
+	Files:      8,
+	Ranks:      8,
+	StartFEN:   "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 3+3 0 1",
+	KingFile:   4,
+	Castling:   true,
+	DoublePush: true,
+	Promotions: "qrbn",
+	Variant:    threeCheck{},
+	Checks:     3,
+}
+
+// KingOfTheHill is standard chess also won by bringing the king to d4, d5,
+// e4 or e5.
+var KingOfTheHill = &RuleSet{
+	Name:       "kingofthehill",
+	Files:      8,
+	Ranks:      8,
+	StartFEN:   StartFEN,
+	KingFile:   4,
+	Castling:   true,
+	DoublePush: true,
+	Promotions: "qrbn",
+	Variant:    kingOfTheHill{},
+}
+
+// RacingKings starts with both armies on the first two ranks and no pawns.
+// Nobody may give check and the first king on the eighth rank wins.
+var RacingKings = &RuleSet{
+	Name:       "racingkings",
+	Files:      8,
+	Ranks:      8,
+	StartFEN:   "8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - - 0 1",
+	KingFile:   4,
+	Promotions: "qrbn",
+	Variant:    racingKings{},
+	NoChecks:   true,
+}
+
+// Antichess is won by losing all pieces or being stalemated. Nobody castles.
+var Antichess = &RuleSet{
+	Name:       "antichess",
+	Files:      8,
+	Ranks:      8,
+	StartFEN:   "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w - - 0 1",
+	KingFile:   4,
+	DoublePush: true,
+	Promotions: "kqrbn",
+	Variant:    antichess{},
+	Antichess:  true,
+}
+
+// Horde pits 36 white pawns without a king against the usual black army.
+// Black wins by taking every white piece, White by checkmate.
+var Horde = &RuleSet{
+	Name:           "horde",
+	Files:          8,
+	Ranks:          8,
+	StartFEN:       "rnbqkbnr/pppppppp/8/1PP2PP1/PPPPPPPP/PPPPPPPP/PPPPPPPP/PPPPPPPP w kq - 0 1",
+	KingFile:       4,
+	Castling:       true,
+	DoublePush:     true,
+	Promotions:     "qrbn",
+	Variant:        horde{},
+	Kingless:       White,
+	FirstRankPawns: true,
+}
+
+var RuleSets = []*RuleSet{Standard, LosAlamos, Gardner, Capablanca, Crazyhouse, Bughouse, Atomic, ThreeCheck, KingOfTheHill, RacingKings, Antichess, Horde}
+
+// RuleSetByName finds the rules by name, ignoring case, spaces and hyphens
+// so that PGN variant tags such as "King of the Hill" match.
+func RuleSetByName(name string) (*RuleSet, error) {
+	for i := 0; i < len(RuleSets); i++ {
+		if RuleSets[i].Name == nameKey(name) {
+			return RuleSets[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown rule set %q", name)
+}
+
+// nameKey folds a name for lookups that ignore case, spaces and hyphens.
+func nameKey(name string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.ToLower(name))
+}
+
+// ruleSetFor picks the rule set a FEN of the given dimensions is played by;
+// a pocket in the FEN asks for drops.
+func ruleSetFor(files int8, ranks int8, drops bool) (*RuleSet, error) {
+	for i := 0; i < len(RuleSets); i++ {
+		r := RuleSets[i]
+		if r.Files == files && r.Ranks == ranks && r.Drops == drops {
+			return r, nil
+		}
+	}
+	if drops {
+		return nil, fmt.Errorf("no rules with drops for a %dx%d board", files, ranks)
+	}
+	return nil, fmt.Errorf("no rules for a %dx%d board", files, ranks)
+}
+
+// NewGame sets up the starting position of the rule set.
+func NewGame(rules *RuleSet) *Game {
+	g, err := ParseFENRules(rules.StartFEN, rules)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// Rules returns the board's rule set, the standard one unless set.
+func (b *Board) Rules() *RuleSet {
+	if b.rules == nil {
+		return Standard
+	}
+	return b.rules
+}
+
+func (b *Board) Files() int8 {
+	return b.Rules().Files
+}
+
+func (b *Board) Ranks() int8 {
+	return b.Rules().Ranks
+}
+
+// Contains tells whether the square is on the board.
+func (b *Board) Contains(s *Square) bool {
+	return s.x >= 0 && s.y >= 0 && s.x < b.Files() && s.y < b.Ranks()
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type serverGame struct {
+	id         string
+	mu         sync.Mutex
+	game       *Game
+	clock      *Clock
+	lastAccess time.Time
+	seats      map[Color]*streamClient
+	clients    map[*streamClient]bool
+	// tokens are the seats' secrets, handed out only when the game is
+	// created. Seeing a seat's view or moving for it takes its token.
+	tokens map[Color]string
+	// umpire referees games the players see only in part; it is nil when
+	// everybody sees the whole board.
+	umpire *Umpire
+}
+
+type Server struct {
+	Expiry      time.Duration
+	MaxMoveTime time.Duration
+
+	mu    sync.Mutex
+	games map[string]*serverGame
+}
+
+func NewServer(expiry time.Duration, maxMoveTime time.Duration) *Server {
+	return &Server{
+		Expiry:      expiry,
+		MaxMoveTime: maxMoveTime,
+		games:       map[string]*serverGame{},
+	}
+}
+
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	expiry := flags.Duration("expiry", time.Hour, "drop games not accessed for this long")
+	maxMoveTime := flags.Duration("max-movetime", 10*time.Second, "upper bound for engine thinking time")
+	flags.Parse(args)
+
+	s := NewServer(*expiry, *maxMoveTime)
+	go s.expireGames()
+	log.Printf("listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, s.Handler()))
+}
+
+// Handler routes the API. The routes are matched here rather than with
+// ServeMux method and wildcard patterns, which are off outside a go1.22
+// module.
+func (s *Server) Handler() http.Handler {
+	routes := map[string]http.HandlerFunc{
+		"POST /games":             s.createGame,
+		"GET /games/{id}":         s.withGame(s.getStatus),
+		"DELETE /games/{id}":      s.deleteGame,
+		"GET /games/{id}/moves":   s.withGame(s.getLegalMoves),
+		"POST /games/{id}/moves":  s.withGame(s.postMove),
+		"POST /games/{id}/undo":   s.withGame(s.postUndo),
+		"GET /games/{id}/fen":     s.withGame(s.getFEN),
+		"GET /games/{id}/pgn":     s.withGame(s.getPGN),
+		"POST /games/{id}/engine": s.withGame(s.postEngine),
+		"GET /games/{id}/ws":      s.streamGame,
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if len(parts) > 1 && parts[0] == "games" && parts[1] != "" {
+			r.SetPathValue("id", parts[1])
+			parts[1] = "{id}"
+		}
+		path := "/" + strings.Join(parts, "/")
+		if h := routes[r.Method+" "+path]; h != nil {
+			h(w, r)
+			return
+		}
+		for route := range routes {
+			if strings.HasSuffix(route, " "+path) {
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "not found")
+	})
+}
+
+func (s *Server) expireGames() {
+	interval := s.Expiry / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	for range time.Tick(interval) {
+		now := time.Now()
+		s.mu.Lock()
+		for id, sg := range s.games {
+			sg.mu.Lock()
+			if len(sg.clients) == 0 && now.Sub(sg.lastAccess) > s.Expiry {
+				delete(s.games, id)
+			}
+			sg.mu.Unlock()
+		}
+		s.mu.Unlock()
+	}
+}
+
+type gameStatus struct {
+	ID      string       `json:"id"`
+	FEN     string       `json:"fen,omitempty"`
+	Turn    string       `json:"turn"`
+	Check   bool         `json:"check"`
+	Result  string       `json:"result"`
+	Reason  string       `json:"reason,omitempty"`
+	History []string     `json:"history"`
+	Clock   *clockStatus `json:"clock,omitempty"`
+
+	// A hidden game in progress shows the board as the seat sees it, '?'
+	// on the squares it does not, and the umpire's announcements in place
+	// of the FEN and the history.
+	View          string   `json:"view,omitempty"`
+	Announcements []string `json:"announcements,omitempty"`
+}
+
+// createdGame answers the creation of a game with the token of each seat.
+type createdGame struct {
+	gameStatus
+	Tokens map[string]string `json:"tokens"`
+}
+
+type clockStatus struct {
+	White   int64  `json:"white_ms"`
+	Black   int64  `json:"black_ms"`
+	Running string `json:"running,omitempty"`
+}
+
+type legalMove struct {
+	UCI string `json:"uci"`
+	SAN string `json:"san"`
+}
+
+type engineReply struct {
+	Move   string      `json:"move"`
+	SAN    string      `json:"san"`
+	Score  int         `json:"score"`
+	Depth  int         `json:"depth"`
+	Nodes  int         `json:"nodes"`
+	PV     []string    `json:"pv"`
+	Status *gameStatus `json:"status,omitempty"`
+}
+
+func (s *Server) createGame(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		FEN       string `json:"fen"`
+		Time      int    `json:"time_ms"`
+		Increment int    `json:"increment_ms"`
+		View      string `json:"view"`
+		Preset    string `json:"preset"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "bad request body: "+err.Error())
+			return
+		}
+	}
+	var game *Game
+	if req.Preset != "" {
+		preset, err := PresetByName(req.Preset)
+		if err == nil {
+			game, err = preset.Game()
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	} else if req.FEN == "" || req.FEN == "startpos" {
+		game = InitGame()
+	} else {
+		g, err := ParseFEN(req.FEN)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		game = g
+	}
+	view, err := ParseView(req.View)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sg := &serverGame{
+		id:         newGameID(),
+		game:       game,
+		lastAccess: time.Now(),
+		seats:      map[Color]*streamClient{},
+		clients:    map[*streamClient]bool{},
+		tokens:     map[Color]string{White: newGameID(), Black: newGameID()},
+	}
+	if view != FullView {
+		sg.umpire = NewUmpire(game, view)
+	}
+	if req.Time > 0 {
+		sg.clock = NewClock(time.Duration(req.Time)*time.Millisecond, time.Duration(req.Increment)*time.Millisecond)
+		go s.runClock(sg)
+	}
+	s.mu.Lock()
+	s.games[sg.id] = sg
+	s.mu.Unlock()
+
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	writeJSON(w, http.StatusCreated, createdGame{
+		gameStatus: sg.status(),
+		Tokens:     map[string]string{"white": sg.tokens[White], "black": sg.tokens[Black]},
+	})
+}
+
+func (s *Server) deleteGame(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	sg := s.lookup(id)
+	if sg == nil {
+		writeError(w, http.StatusNotFound, "no such game")
+		return
+	}
+	seat, ok := sg.requestSeat(w, r)
+	if !ok {
+		return
+	}
+	if seat == 0 {
+		writeError(w, http.StatusForbidden, "only a player may delete the game")
+		return
+	}
+	s.mu.Lock()
+	delete(s.games, id)
+	s.mu.Unlock()
+	sg.mu.Lock()
+	sg.closeClients()
+	sg.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// withGame looks the game up and holds its lock for the whole request.
+func (s *Server) withGame(h func(http.ResponseWriter, *http.Request, *serverGame)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sg := s.lookup(r.PathValue("id"))
+		if sg == nil {
+			writeError(w, http.StatusNotFound, "no such game")
+			return
+		}
+		sg.mu.Lock()
+		defer sg.mu.Unlock()
+		sg.lastAccess = time.Now()
+		h(w, r, sg)
+	}
+}
+
+func (s *Server) lookup(id string) *serverGame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.games[id]
+}
+
+// getStatus shows a hidden game as the seat in the query sees it.
+func (s *Server) getStatus(w http.ResponseWriter, r *http.Request, sg *serverGame) {
+	seat, ok := sg.requestSeat(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, sg.statusFor(seat))
+}
+
+// requestSeat reads the seat a request speaks for from its seat and token
+// query parameters; without a seat it observes. It writes the error and
+// returns false when the token is not the seat's.
+func (sg *serverGame) requestSeat(w http.ResponseWriter, r *http.Request) (Color, bool) {
+	query := r.URL.Query()
+	seat, err := parseSeat(query.Get("seat"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return 0, false
+	}
+	token := query.Get("token")
+	if seat != 0 && subtle.ConstantTimeCompare([]byte(token), []byte(sg.tokens[seat])) != 1 {
+		writeError(w, http.StatusForbidden, "wrong token for the "+colorName(seat)+" seat")
+		return 0, false
+	}
+	return seat, true
+}
+
+// hidden tells whether the players may not yet see the whole game.
+func (sg *serverGame) hidden() bool {
+	return sg.umpire != nil && sg.game.Result() == Ongoing
+}
+
+func (s *Server) getLegalMoves(w http.ResponseWriter, r *http.Request, sg *serverGame) {
+	if sg.hidden() {
+		writeError(w, http.StatusForbidden, "the game is hidden until it is over")
+		return
+	}
+	g := sg.game
+	moves := g.possibleMoves()
+	legal := make([]legalMove, len(moves))
+	for i := 0; i < len(moves); i++ {
+		legal[i] = legalMove{UCI: moves[i].UCI(), SAN: g.SAN(moves[i])}
+	}
+	writeJSON(w, http.StatusOK, legal)
+}
+
+func (s *Server) postMove(w http.ResponseWriter, r *http.Request, sg *serverGame) {
+	mover, ok := sg.requestSeat(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Move string `json:"move"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "bad request body: "+err.Error())
+		return
+	}
+	if sg.game.Result() != Ongoing {
+		writeError(w, http.StatusConflict, "game is over")
+		return
+	}
+	if mover != sg.game.OnTurn {
+		writeError(w, http.StatusForbidden, "only the side on turn may move")
+		return
+	}
+	m, err := sg.try(req.Move)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	sg.play(m)
+	writeJSON(w, http.StatusOK, sg.statusFor(mover))
+}
+
+// try finds the legal move the side on turn asked for. In a hidden game the
+// umpire announces an illegal attempt to everybody.
+func (sg *serverGame) try(s string) (*Move, error) {
+	if sg.umpire == nil {
+		return sg.game.ParseMove(s)
+	}
+	m := sg.umpire.Judge(s)
+	if m == nil {
+		sg.broadcast(streamEvent{Type: "umpire", Text: "illegal"})
+		return nil, fmt.Errorf("illegal move %q", s)
+	}
+	return m, nil
+}
+
+func (s *Server) postUndo(w http.ResponseWriter, r *http.Request, sg *serverGame) {
+	g := sg.game
+	seat, ok := sg.requestSeat(w, r)
+	if !ok {
+		return
+	}
+	if seat == 0 {
+		writeError(w, http.StatusForbidden, "only a player may take back a move")
+		return
+	}
+	if sg.umpire != nil {
+		writeError(w, http.StatusForbidden, "no takebacks in a hidden game")
+		return
+	}
+	if len(g.Moves) == 0 {
+		writeError(w, http.StatusConflict, "no move to undo")
+		return
+	}
+	g.undoMove(g.Moves[len(g.Moves)-1])
+	sg.broadcastState()
+	writeJSON(w, http.StatusOK, sg.status())
+}
+
+func (s *Server) getFEN(w http.ResponseWriter, r *http.Request, sg *serverGame) {
+	if sg.hidden() {
+		writeError(w, http.StatusForbidden, "the game is hidden until it is over")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(sg.game.FEN() + "\n"))
+}
+
+func (s *Server) getPGN(w http.ResponseWriter, r *http.Request, sg *serverGame) {
+	if sg.hidden() {
+		writeError(w, http.StatusForbidden, "the game is hidden until it is over")
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-chess-pgn")
+	w.Write([]byte(sg.game.PGN(map[string]string{"Site": r.Host, "Event": "Game " + sg.id})))
+}
+
+func (s *Server) postEngine(w http.ResponseWriter, r *http.Request, sg *serverGame) {
+	g := sg.game
+	seat, ok := sg.requestSeat(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Depth    int  `json:"depth"`
+		MoveTime int  `json:"movetime"`
+		Play     bool `json:"play"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "bad request body: "+err.Error())
+			return
+		}
+	}
+	if g.Result() != Ongoing {
+		writeError(w, http.StatusConflict, "game is over")
+		return
+	}
+	if sg.umpire != nil {
+		writeError(w, http.StatusForbidden, "the engine would see the hidden game")
+		return
+	}
+	if req.Play && seat != g.OnTurn {
+		writeError(w, http.StatusForbidden, "only the side on turn may play the engine's move")
+		return
+	}
+	engine := &Engine{
+		Depth:    req.Depth,
+		MoveTime: time.Duration(req.MoveTime) * time.Millisecond,
+	}
+	if engine.MoveTime <= 0 || engine.MoveTime > s.MaxMoveTime {
+		engine.MoveTime = s.MaxMoveTime
+	}
+	result := engine.Search(g)
+
+	reply := engineReply{
+		Move:  result.Move.UCI(),
+		SAN:   g.SAN(result.Move),
+		Score: result.Score,
+		Depth: result.Depth,
+		Nodes: result.Nodes,
+		PV:    []string{},
+	}
+	for i := 0; i < len(result.PV); i++ {
+		reply.PV = append(reply.PV, result.PV[i].UCI())
+	}
+	if req.Play {
+		sg.play(result.Move)
+		st := sg.status()
+		reply.Status = &st
+	}
+	writeJSON(w, http.StatusOK, reply)
+}
+
+// play makes the move, presses the clock and notifies the stream clients.
+func (sg *serverGame) play(m *Move) {
+	g := sg.game
+	san := g.SAN(m)
+	if sg.clock != nil && !sg.clock.Press(g.OnTurn) {
+		sg.flagFall(g.OnTurn)
+		return
+	}
+	g.doMove(m)
+	if sg.umpire != nil {
+		sg.broadcastHidden(m, san)
+	} else {
+		st := sg.status()
+		sg.broadcast(streamEvent{Type: "move", Move: m.UCI(), SAN: san, Status: &st})
+	}
+	if result, reason := g.Outcome(); result != Ongoing {
+		if sg.clock != nil {
+			sg.clock.Stop()
+		}
+		sg.broadcast(streamEvent{Type: "result", Result: string(result), Reason: reason})
+	}
+}
+
+func (sg *serverGame) flagFall(color Color) {
+	g := sg.game
+	switch {
+	case !g.canMate(-color):
+		g.Adjudicate(Draw, "timeout vs insufficient material")
+	case color == White:
+		g.Adjudicate(BlackWins, "timeout")
+	default:
+		g.Adjudicate(WhiteWins, "timeout")
+	}
+	sg.clock.Stop()
+	result, reason := g.Outcome()
+	sg.broadcast(streamEvent{Type: "result", Result: string(result), Reason: reason})
+}
+
+// broadcastHidden tells every client of a hidden game what the umpire
+// announced about the move and shows each the board as its seat sees it.
+// Only the mover learns the move itself.
+func (sg *serverGame) broadcastHidden(m *Move, san string) {
+	said := sg.umpire.Played(m)
+	mover := -sg.game.OnTurn
+	for c := range sg.clients {
+		st := sg.statusFor(c.seat)
+		ev := streamEvent{Type: "move", Status: &st}
+		if c.seat == mover {
+			ev.Move, ev.SAN = m.UCI(), san
+		}
+		sg.sendTo(c, ev)
+		for i := 0; i < len(said); i++ {
+			sg.sendTo(c, streamEvent{Type: "umpire", Text: said[i]})
+		}
+	}
+}
+
+// status is the game as observers see it.
+func (sg *serverGame) status() gameStatus {
+	return sg.statusFor(0)
+}
+
+// statusFor is the game as the seat sees it; the zero seat observes.
+func (sg *serverGame) statusFor(seat Color) gameStatus {
+	g := sg.game
+	id := sg.id
+	result, reason := g.Outcome()
+	turn := "white"
+	if g.OnTurn == Black {
+		turn = "black"
+	}
+	st := gameStatus{
+		ID:      id,
+		FEN:     g.FEN(),
+		Turn:    turn,
+		Check:   g.Board.inCheck(g.OnTurn),
+		Result:  string(result),
+		Reason:  reason,
+		History: g.sanHistory(),
+		Clock:   sg.clockStatus(),
+	}
+	if sg.hidden() {
+		st.FEN = ""
+		st.History = []string{}
+		st.View = g.Board.viewPlacement(seat, sg.umpire.View)
+		st.Announcements = sg.umpire.Log
+	}
+	return st
+}
+
+func (sg *serverGame) clockStatus() *clockStatus {
+	if sg.clock == nil {
+		return nil
+	}
+	cs := &clockStatus{
+		White: sg.clock.Remaining(White).Milliseconds(),
+		Black: sg.clock.Remaining(Black).Milliseconds(),
+	}
+	switch sg.clock.Running() {
+	case White:
+		cs.Running = "white"
+	case Black:
+		cs.Running = "black"
+	}
+	return cs
+}
+
+func newGameID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSeatTokens(t *testing.T) {
+	srv := httptest.NewServer(NewServer(time.Hour, time.Second).Handler())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/games", "application/json", strings.NewReader(`{"view":"kriegspiel"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created createdGame
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	white, black := created.Tokens["white"], created.Tokens["black"]
+	if white == "" || black == "" || white == black {
+		t.Fatalf("tokens: %v", created.Tokens)
+	}
+	game := srv.URL + "/games/" + created.ID
+
+	// each seat sees its own back rank and not the other's
+	reads := []struct {
+		query string
+		code  int
+		sees  string
+		hides string
+	}{
+		{"", http.StatusOK, "", ""},
+		{"?seat=white", http.StatusForbidden, "", ""},
+		{"?seat=white&token=" + black, http.StatusForbidden, "", ""},
+		{"?seat=white&token=" + white, http.StatusOK, "RNBQKBNR", "rnbqkbnr"},
+		{"?seat=black&token=" + black, http.StatusOK, "rnbqkbnr", "RNBQKBNR"},
+	}
+	for i := 0; i < len(reads); i++ {
+		resp, err := http.Get(game + reads[i].query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var st gameStatus
+		json.NewDecoder(resp.Body).Decode(&st)
+		resp.Body.Close()
+		if resp.StatusCode != reads[i].code {
+			t.Errorf("GET %q: status %d, want %d", reads[i].query, resp.StatusCode, reads[i].code)
+		}
+		if reads[i].sees != "" && (!strings.Contains(st.View, reads[i].sees) || strings.Contains(st.View, reads[i].hides)) {
+			t.Errorf("GET %q: view %q", reads[i].query, st.View)
+		}
+	}
+
+	moves := []struct {
+		query string
+		code  int
+	}{
+		{"", http.StatusForbidden},
+		{"?seat=white&token=" + black, http.StatusForbidden},
+		{"?seat=black&token=" + black, http.StatusForbidden},
+		{"?seat=white&token=" + white, http.StatusOK},
+	}
+	for i := 0; i < len(moves); i++ {
+		resp, err := http.Post(game+"/moves"+moves[i].query, "application/json", strings.NewReader(`{"move":"e2e4"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != moves[i].code {
+			t.Errorf("POST moves %q: status %d, want %d", moves[i].query, resp.StatusCode, moves[i].code)
+		}
+	}
+
+	wsURL := "ws" + strings.TrimPrefix(game, "http") + "/ws"
+	if ws, err := DialWebSocket(wsURL + "?seat=black&token=" + white); err == nil {
+		ws.Close()
+		t.Error("took the black seat with White's token")
+	}
+	ws, err := DialWebSocket(wsURL + "?seat=black&token=" + black)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.Close()
+}
+
+func TestRoutes(t *testing.T) {
+	srv := httptest.NewServer(NewServer(time.Hour, time.Second).Handler())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/games", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created createdGame
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || created.ID == "" {
+		t.Fatalf("POST /games: status %d, id %q", resp.StatusCode, created.ID)
+	}
+	requests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"GET", "/games/" + created.ID, http.StatusOK},
+		{"GET", "/games/" + created.ID + "/fen", http.StatusOK},
+		{"GET", "/games/" + created.ID + "/moves", http.StatusOK},
+		{"PUT", "/games/" + created.ID + "/fen", http.StatusMethodNotAllowed},
+		{"GET", "/games", http.StatusMethodNotAllowed},
+		{"GET", "/games/unknown", http.StatusNotFound},
+		{"GET", "/games/" + created.ID + "/board", http.StatusNotFound},
+		{"GET", "/", http.StatusNotFound},
+	}
+	for i := 0; i < len(requests); i++ {
+		req, _ := http.NewRequest(requests[i].method, srv.URL+requests[i].path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != requests[i].code {
+			t.Errorf("%s %s: status %d, want %d", requests[i].method, requests[i].path, resp.StatusCode, requests[i].code)
+		}
+	}
+}
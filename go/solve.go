@@ -0,0 +1,406 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type Stipulation int
+
+const (
+	// DirectMate: the side to move mates against any defence.
+	DirectMate Stipulation = iota
+	// SelfMate: the side to move forces its opponent to mate it.
+	SelfMate
+	// ReflexMate is a selfmate where either side that can mate in one
+	// move has to.
+	ReflexMate
+)
+
+// MateSolver solves mate problems with the side to move as the attacker.
+type MateSolver struct {
+	Stipulation Stipulation
+	memo        map[string]bool
+	Nodes       int
+}
+
+func NewMateSolver(stipulation Stipulation) *MateSolver {
+	return &MateSolver{Stipulation: stipulation, memo: map[string]bool{}}
+}
+
+// moves returns the moves the side on turn may play, in a reflexmate only
+// the mating ones when there are any.
+func (s *MateSolver) moves(g *Game) []*Move {
+	moves := g.possibleMoves()
+	if s.Stipulation != ReflexMate {
+		return moves
+	}
+	mates := []*Move{}
+	for i := 0; i < len(moves); i++ {
+		g.doMove(moves[i])
+		if g.isCheckmate() {
+			mates = append(mates, moves[i])
+		}
+		g.undoMove(moves[i])
+	}
+	if len(mates) > 0 {
+		return mates
+	}
+	return moves
+}
+
+// Forces tells whether the side to move achieves the stipulation in at most
+// n moves.
+func (s *MateSolver) Forces(g *Game, n int) bool {
+	if n <= 0 {
+		return false
+	}
+	key := g.positionKey() + " " + strconv.Itoa(n)
+	if mate, ok := s.memo[key]; ok {
+		return mate
+	}
+	moves := s.moves(g)
+	orderMoves(moves)
+	mate := false
+	for i := 0; i < len(moves) && !mate; i++ {
+		g.doMove(moves[i])
+		mate = s.mated(g, s.left(n))
+		g.undoMove(moves[i])
+	}
+	s.memo[key] = mate
+	return mate
+}
+
+// left converts the moves of a stipulation into the argument of mated after
+// the attacker's move: attacker moves left for direct mates, defender moves
+// left otherwise.
+func (s *MateSolver) left(n int) int {
+	if s.Stipulation == DirectMate {
+		return n - 1
+	}
+	return n
+}
+
+// mated tells whether the defender on turn cannot escape the stipulation:
+// in a direct mate it is mated now or in n more attacker moves, otherwise it
+// has to mate the attacker within its n next moves.
+func (s *MateSolver) mated(g *Game, n int) bool {
+	s.Nodes++
+	if s.Stipulation != DirectMate {
+		if n <= 0 {
+			return false
+		}
+		defences := s.moves(g)
+		if len(defences) == 0 {
+			return false
+		}
+		for i := 0; i < len(defences); i++ {
+			g.doMove(defences[i])
+			mate := g.isCheckmate() || s.Forces(g, n-1)
+			g.undoMove(defences[i])
+			if !mate {
+				return false
+			}
+		}
+		return true
+	}
+
+	inCheck := g.Board.inCheck(g.OnTurn)
+	if n == 0 && !inCheck {
+		return false
+	}
+	defences := g.possibleMoves()
+	if len(defences) == 0 {
+		return inCheck
+	}
+	for i := 0; i < len(defences); i++ {
+		g.doMove(defences[i])
+		mate := s.Forces(g, n)
+		g.undoMove(defences[i])
+		if !mate {
+			return false
+		}
+	}
+	return true
+}
+
+// SolutionMove is a move of the attacker in the solution tree with the
+// threat it carries and every defence against it.
+type SolutionMove struct {
+	SAN      string
+	Threats  []string
+	Defences []*SolutionDefence
+}
+
+// SolutionDefence lists all continuations that still mate in time, more
+// than one of them is a dual.
+type SolutionDefence struct {
+	SAN     string
+	Replies []*SolutionMove
+}
+
+type MateSolution struct {
+	Moves int
+	Keys  []*SolutionMove
+	// Short holds the keys that mate in fewer moves than stipulated.
+	Short []string
+}
+
+// SolveMate finds every key of the stipulation in n and the full solution
+// tree.
+func (s *MateSolver) SolveMate(g *Game, n int) *MateSolution {
+	solution := &MateSolution{Moves: n}
+	moves := s.moves(g)
+	for i := 0; i < len(moves); i++ {
+		m := moves[i]
+		san := g.SAN(m)
+		g.doMove(m)
+		if s.mated(g, s.left(n)) {
+			solution.Keys = append(solution.Keys, s.tree(g, san, s.left(n)))
+			if n > 1 && s.mated(g, s.left(n)-1) {
+				solution.Short = append(solution.Short, san)
+			}
+		}
+		g.undoMove(m)
+	}
+	return solution
+}
+
+// tree expands the position after the attacker's move san, n is as for
+// mated.
+func (s *MateSolver) tree(g *Game, san string, n int) *SolutionMove {
+	node := &SolutionMove{SAN: san}
+	if s.Stipulation == DirectMate && (n == 0 || g.isCheckmate()) {
+		return node
+	}
+	node.Threats = s.threats(g, n)
+	defences := s.moves(g)
+	for i := 0; i < len(defences); i++ {
+		d := defences[i]
+		defence := &SolutionDefence{SAN: g.SAN(d)}
+		g.doMove(d)
+		if s.Stipulation != DirectMate && g.isCheckmate() {
+			g.undoMove(d)
+			node.Defences = append(node.Defences, defence)
+			continue
+		}
+		replies := s.moves(g)
+		for j := 0; j < len(replies); j++ {
+			r := replies[j]
+			replySAN := g.SAN(r)
+			g.doMove(r)
+			if s.mated(g, n-1) {
+				defence.Replies = append(defence.Replies, s.tree(g, replySAN, n-1))
+			}
+			g.undoMove(r)
+		}
+		g.undoMove(d)
+		node.Defences = append(node.Defences, defence)
+	}
+	return node
+}
+
+// threats returns the moves that would mate in n if the defender could
+// pass. There is no threat while the defender is in check.
+func (s *MateSolver) threats(g *Game, n int) []string {
+	if g.Board.inCheck(g.OnTurn) {
+		return nil
+	}
+	ep := g.Board.EnpassantSquare
+	g.Board.EnpassantSquare = nil
+	g.OnTurn = -g.OnTurn
+	threats := []string{}
+	moves := s.moves(g)
+	for i := 0; i < len(moves); i++ {
+		m := moves[i]
+		san := g.SAN(m)
+		g.doMove(m)
+		if s.mated(g, n-1) {
+			threats = append(threats, san)
+		}
+		g.undoMove(m)
+	}
+	g.OnTurn = -g.OnTurn
+	g.Board.EnpassantSquare = ep
+	return threats
+}
+
+// Helpmates returns every solution of a helpmate in n: the side to move
+// helps its opponent to mate it on the opponent's n-th move. Each solution
+// is written as 1.Kd4 Qe2 2.Kc5 Qb5#.
+func (s *MateSolver) Helpmates(g *Game, n int) []string {
+	solutions := []string{}
+	s.helpmates(g, n, 1, "", &solutions)
+	return solutions
+}
+
+func (s *MateSolver) helpmates(g *Game, n int, number int, line string, solutions *[]string) {
+	if !s.canHelpmate(g, n) {
+		return
+	}
+	moves := g.possibleMoves()
+	for i := 0; i < len(moves); i++ {
+		m := moves[i]
+		san := g.SAN(m)
+		g.doMove(m)
+		replies := g.possibleMoves()
+		for j := 0; j < len(replies); j++ {
+			r := replies[j]
+			pair := fmt.Sprintf("%d.%s %s", number, san, g.SAN(r))
+			if line != "" {
+				pair = line + " " + pair
+			}
+			g.doMove(r)
+			if n == 1 {
+				if g.isCheckmate() {
+					*solutions = append(*solutions, pair)
+				}
+			} else {
+				s.helpmates(g, n-1, number+1, pair, solutions)
+			}
+			g.undoMove(r)
+		}
+		g.undoMove(m)
+	}
+}
+
+func (s *MateSolver) canHelpmate(g *Game, n int) bool {
+	key := "h " + g.positionKey() + " " + strconv.Itoa(n)
+	if mate, ok := s.memo[key]; ok {
+		return mate
+	}
+	s.Nodes++
+	mate := false
+	moves := g.possibleMoves()
+	for i := 0; i < len(moves) && !mate; i++ {
+		g.doMove(moves[i])
+		replies := g.possibleMoves()
+		for j := 0; j < len(replies) && !mate; j++ {
+			g.doMove(replies[j])
+			if n == 1 {
+				mate = g.isCheckmate()
+			} else {
+				mate = s.canHelpmate(g, n-1)
+			}
+			g.undoMove(replies[j])
+		}
+		g.undoMove(moves[i])
+	}
+	s.memo[key] = mate
+	return mate
+}
+
+// Duals counts the defences answered by more than one continuation.
+func (m *SolutionMove) Duals() int {
+	duals := 0
+	for i := 0; i < len(m.Defences); i++ {
+		d := m.Defences[i]
+		if len(d.Replies) > 1 {
+			duals++
+		}
+		for j := 0; j < len(d.Replies); j++ {
+			duals += d.Replies[j].Duals()
+		}
+	}
+	return duals
+}
+
+// Print writes the solution in the usual notation: 1.Qh5! (2.Qxf7#) with
+// the defences indented below, duals separated by slashes.
+func (sol *MateSolution) Print() {
+	if len(sol.Keys) == 0 {
+		fmt.Printf("no solution in %d\n", sol.Moves)
+		return
+	}
+	for i := 0; i < len(sol.Keys); i++ {
+		sol.Keys[i].print(1, "!", "")
+	}
+	keys := []string{}
+	duals := 0
+	for i := 0; i < len(sol.Keys); i++ {
+		keys = append(keys, sol.Keys[i].SAN)
+		duals += sol.Keys[i].Duals()
+	}
+	fmt.Printf("\nkey: %s\n", strings.Join(keys, ", "))
+	if len(keys) > 1 {
+		fmt.Printf("cooked: %d keys\n", len(keys))
+	} else {
+		fmt.Println("unique key")
+	}
+	if len(sol.Short) > 0 {
+		fmt.Printf("short mate: %s\n", strings.Join(sol.Short, ", "))
+	}
+	fmt.Printf("duals: %d\n", duals)
+}
+
+func (m *SolutionMove) print(number int, mark string, indent string) {
+	line := fmt.Sprintf("%s%d.%s%s", indent, number, m.SAN, mark)
+	if len(m.Threats) > 0 {
+		threats := []string{}
+		for i := 0; i < len(m.Threats); i++ {
+			threats = append(threats, fmt.Sprintf("%d.%s", number+1, m.Threats[i]))
+		}
+		line += " (" + strings.Join(threats, " / ") + ")"
+	}
+	fmt.Println(line)
+	for i := 0; i < len(m.Defences); i++ {
+		d := m.Defences[i]
+		// final mates go on the defence line, longer continuations below it
+		mates := []string{}
+		for j := 0; j < len(d.Replies); j++ {
+			if len(d.Replies[j].Defences) == 0 {
+				mates = append(mates, fmt.Sprintf("%d.%s", number+1, d.Replies[j].SAN))
+			}
+		}
+		fmt.Printf("%s  %d...%s %s\n", indent, number, d.SAN, strings.Join(mates, " / "))
+		for j := 0; j < len(d.Replies); j++ {
+			r := d.Replies[j]
+			if len(r.Defences) > 0 {
+				r.print(number+1, "", indent+"    ")
+			}
+		}
+	}
+}
+
+func solveCommand(args []string) {
+	flags := flag.NewFlagSet("solve", flag.ExitOnError)
+	fen := flags.String("fen", "", "problem position")
+	mate := flags.Int("mate", 0, "direct mate in n moves")
+	helpmate := flags.Int("helpmate", 0, "helpmate in n moves, the side to move is mated")
+	selfmate := flags.Int("selfmate", 0, "selfmate in n moves")
+	reflexmate := flags.Int("reflexmate", 0, "reflexmate in n moves")
+	flags.Parse(args)
+
+	g, err := ParseFEN(*fen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch {
+	case *mate > 0:
+		NewMateSolver(DirectMate).SolveMate(g, *mate).Print()
+	case *selfmate > 0:
+		NewMateSolver(SelfMate).SolveMate(g, *selfmate).Print()
+	case *reflexmate > 0:
+		NewMateSolver(ReflexMate).SolveMate(g, *reflexmate).Print()
+	case *helpmate > 0:
+		solutions := NewMateSolver(DirectMate).Helpmates(g, *helpmate)
+		for i := 0; i < len(solutions); i++ {
+			fmt.Println(solutions[i])
+		}
+		switch len(solutions) {
+		case 0:
+			fmt.Printf("no solution in %d\n", *helpmate)
+		case 1:
+			fmt.Println("\nunique solution")
+		default:
+			fmt.Printf("\n%d solutions\n", len(solutions))
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "usage: chess solve -fen FEN [-mate|-helpmate|-selfmate|-reflexmate] N")
+		os.Exit(2)
+	}
+}
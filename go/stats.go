@@ -0,0 +1,143 @@
+package main
+
+import "math"
+
+// Elo converts an expected score into an Elo difference.
+func Elo(score float64) float64 {
+	if score <= 0 {
+		return math.Inf(-1)
+	}
+	if score >= 1 {
+		return math.Inf(1)
+	}
+	return 400 * math.Log10(score/(1-score))
+}
+
+// EloMargin returns the Elo difference of a match result together with the
+// half width of its 95% confidence interval.
+func EloMargin(wins int, draws int, losses int) (float64, float64) {
+	n := float64(wins + draws + losses)
+	if n == 0 {
+		return 0, 0
+	}
+	w := float64(wins) / n
+	d := float64(draws) / n
+	l := float64(losses) / n
+	score := w + d/2
+	variance := w*math.Pow(1-score, 2) + d*math.Pow(0.5-score, 2) + l*math.Pow(score, 2)
+	stderr := math.Sqrt(variance / n)
+	low := Elo(score - 1.96*stderr)
+	high := Elo(score + 1.96*stderr)
+	return Elo(score), (high - low) / 2
+}
+
+// LOS is the likelihood of superiority, draws do not count.
+func LOS(wins int, losses int) float64 {
+	if wins+losses == 0 {
+		return 0.5
+	}
+	return 0.5 * (1 + math.Erf(float64(wins-losses)/math.Sqrt(2*float64(wins+losses))))
+}
+
+// SPRT is a sequential probability ratio test of H0: elo = Elo0 against
+// H1: elo = Elo1 using the generalized SPRT with the maximum likelihood
+// distributions of the observed results. The trinomial model counts single
+// games (loss, draw, win), the pentanomial model counts game pairs played on
+// the same opening by their total score (0, 0.5, 1, 1.5 or 2 points).
+type SPRT struct {
+	Elo0        float64
+	Elo1        float64
+	Alpha       float64
+	Beta        float64
+	Pentanomial bool
+}
+
+func (s SPRT) Bounds() (float64, float64) {
+	return math.Log(s.Beta / (1 - s.Alpha)), math.Log((1 - s.Beta) / s.Alpha)
+}
+
+// LLR takes the counts of the outcomes, worst first: 3 for the trinomial
+// model and 5 for the pentanomial one.
+func (s SPRT) LLR(counts []int) float64 {
+	n := 0.0
+	for i := 0; i < len(counts); i++ {
+		n += float64(counts[i])
+	}
+	if n == 0 {
+		return 0
+	}
+	// zero counts would make the distributions degenerate
+	probs := make([]float64, len(counts))
+	scores := make([]float64, len(counts))
+	total := 0.0
+	for i := 0; i < len(counts); i++ {
+		probs[i] = float64(counts[i])
+		if probs[i] == 0 {
+			probs[i] = 1e-3
+		}
+		total += probs[i]
+		scores[i] = float64(i) / float64(len(counts)-1)
+	}
+	for i := 0; i < len(probs); i++ {
+		probs[i] /= total
+	}
+	p0 := mleDistribution(probs, scores, expectedScore(s.Elo0))
+	p1 := mleDistribution(probs, scores, expectedScore(s.Elo1))
+	llr := 0.0
+	for i := 0; i < len(probs); i++ {
+		llr += probs[i] * math.Log(p1[i]/p0[i])
+	}
+	return n * llr
+}
+
+// Status returns "H0" or "H1" once one of them is accepted, "" otherwise.
+func (s SPRT) Status(llr float64) string {
+	lower, upper := s.Bounds()
+	if llr <= lower {
+		return "H0"
+	}
+	if llr >= upper {
+		return "H1"
+	}
+	return ""
+}
+
+func expectedScore(elo float64) float64 {
+	return 1 / (1 + math.Pow(10, -elo/400))
+}
+
+// mleDistribution finds the distribution closest to probs whose expected
+// score is mean: p_i = probs_i / (1 + lambda*(scores_i - mean)).
+func mleDistribution(probs []float64, scores []float64, mean float64) []float64 {
+	minDiff, maxDiff := 0.0, 0.0
+	for i := 0; i < len(scores); i++ {
+		d := scores[i] - mean
+		minDiff = math.Min(minDiff, d)
+		maxDiff = math.Max(maxDiff, d)
+	}
+	low := -1/maxDiff + 1e-9
+	high := -1/minDiff - 1e-9
+	f := func(lambda float64) float64 {
+		sum := 0.0
+		for i := 0; i < len(probs); i++ {
+			d := scores[i] - mean
+			sum += probs[i] * d / (1 + lambda*d)
+		}
+		return sum
+	}
+	// f is decreasing in lambda
+	for iter := 0; iter < 200; iter++ {
+		mid := (low + high) / 2
+		if f(mid) > 0 {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	lambda := (low + high) / 2
+	p := make([]float64, len(probs))
+	for i := 0; i < len(probs); i++ {
+		p[i] = probs[i] / (1 + lambda*(scores[i]-mean))
+	}
+	return p
+}
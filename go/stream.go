@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Events pushed to the clients of GET /games/{id}/ws. Players connect with
+// ?seat=white&token=... using the token the game was created with and send
+// {"type":"move","move":"e2e4"}; observers only listen. In a hidden game
+// "umpire" events carry the announcements in Text.
+type streamEvent struct {
+	Type   string       `json:"type"`
+	Seat   string       `json:"seat,omitempty"`
+	Move   string       `json:"move,omitempty"`
+	SAN    string       `json:"san,omitempty"`
+	Result string       `json:"result,omitempty"`
+	Reason string       `json:"reason,omitempty"`
+	Error  string       `json:"error,omitempty"`
+	Text   string       `json:"text,omitempty"`
+	Status *gameStatus  `json:"status,omitempty"`
+	Clock  *clockStatus `json:"clock,omitempty"`
+}
+
+type streamClient struct {
+	ws   *WebSocket
+	seat Color
+	send chan []byte
+}
+
+func (s *Server) streamGame(w http.ResponseWriter, r *http.Request) {
+	sg := s.lookup(r.PathValue("id"))
+	if sg == nil {
+		writeError(w, http.StatusNotFound, "no such game")
+		return
+	}
+	seat, ok := sg.requestSeat(w, r)
+	if !ok {
+		return
+	}
+
+	sg.mu.Lock()
+	taken := seat != 0 && sg.seats[seat] != nil
+	sg.mu.Unlock()
+	if taken {
+		writeError(w, http.StatusConflict, "seat already taken")
+		return
+	}
+	// the handshake talks to the network, so the game stays unlocked
+	// meanwhile and the seat is checked again after it
+	ws, err := UpgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	c := &streamClient{ws: ws, seat: seat, send: make(chan []byte, 64)}
+	sg.mu.Lock()
+	if seat != 0 && sg.seats[seat] != nil {
+		sg.mu.Unlock()
+		ws.WriteJSON(streamEvent{Type: "error", Error: "seat already taken"})
+		ws.Close()
+		return
+	}
+	if seat != 0 {
+		sg.seats[seat] = c
+	}
+	sg.clients[c] = true
+	sg.lastAccess = time.Now()
+	st := sg.statusFor(seat)
+	sg.sendTo(c, streamEvent{Type: "state", Seat: colorName(seat), Status: &st})
+	sg.mu.Unlock()
+
+	go c.writeLoop()
+	s.readLoop(sg, c)
+}
+
+func (c *streamClient) writeLoop() {
+	for data := range c.send {
+		if err := c.ws.WriteMessage(data); err != nil {
+			break
+		}
+	}
+	c.ws.Close()
+}
+
+func (s *Server) readLoop(sg *serverGame, c *streamClient) {
+	defer func() {
+		sg.mu.Lock()
+		sg.removeClient(c)
+		sg.mu.Unlock()
+	}()
+	for {
+		var ev streamEvent
+		if err := c.ws.ReadJSON(&ev); err != nil {
+			return
+		}
+		sg.mu.Lock()
+		sg.lastAccess = time.Now()
+		sg.handleEvent(c, ev)
+		sg.mu.Unlock()
+	}
+}
+
+func (sg *serverGame) handleEvent(c *streamClient, ev streamEvent) {
+	if ev.Type != "move" {
+		sg.sendTo(c, streamEvent{Type: "error", Error: fmt.Sprintf("unknown event %q", ev.Type)})
+		return
+	}
+	g := sg.game
+	if c.seat == 0 {
+		sg.sendTo(c, streamEvent{Type: "error", Error: "observers cannot move"})
+		return
+	}
+	if c.seat != g.OnTurn {
+		sg.sendTo(c, streamEvent{Type: "error", Error: "not your turn"})
+		return
+	}
+	if g.Result() != Ongoing {
+		sg.sendTo(c, streamEvent{Type: "error", Error: "game is over"})
+		return
+	}
+	m, err := sg.try(ev.Move)
+	if err != nil {
+		sg.sendTo(c, streamEvent{Type: "error", Error: err.Error()})
+		return
+	}
+	sg.play(m)
+}
+
+// parseSeat reads the seat of a query, the empty one observing.
+func parseSeat(name string) (Color, error) {
+	switch name {
+	case "":
+		return 0, nil
+	case "white":
+		return White, nil
+	case "black":
+		return Black, nil
+	}
+	return 0, fmt.Errorf("seat must be white or black")
+}
+
+// sendTo queues an event for one client, dropping clients that do not keep
+// up. Callers hold sg.mu.
+func (sg *serverGame) sendTo(c *streamClient, ev streamEvent) {
+	if !sg.clients[c] {
+		return
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	select {
+	case c.send <- data:
+	default:
+		sg.removeClient(c)
+	}
+}
+
+func (sg *serverGame) broadcast(ev streamEvent) {
+	for c := range sg.clients {
+		sg.sendTo(c, ev)
+	}
+}
+
+func (sg *serverGame) broadcastState() {
+	st := sg.status()
+	sg.broadcast(streamEvent{Type: "state", Status: &st})
+}
+
+func (sg *serverGame) removeClient(c *streamClient) {
+	if !sg.clients[c] {
+		return
+	}
+	delete(sg.clients, c)
+	if c.seat != 0 && sg.seats[c.seat] == c {
+		delete(sg.seats, c.seat)
+	}
+	close(c.send)
+}
+
+func (sg *serverGame) closeClients() {
+	for c := range sg.clients {
+		sg.removeClient(c)
+	}
+}
+
+// runClock watches the clock every 100ms to end the game on a flag fall
+// and pushes clock updates once a second, until the game is over or removed
+// from the server.
+func (s *Server) runClock(sg *serverGame) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	lastUpdate := time.Now()
+	for range ticker.C {
+		if s.lookup(sg.id) != sg {
+			return
+		}
+		sg.mu.Lock()
+		if sg.game.Result() != Ongoing {
+			sg.mu.Unlock()
+			return
+		}
+		if flagged := sg.clock.Flagged(); flagged != 0 {
+			sg.flagFall(flagged)
+			sg.mu.Unlock()
+			return
+		}
+		if sg.clock.Running() != 0 && time.Since(lastUpdate) >= time.Second {
+			sg.broadcast(streamEvent{Type: "clock", Clock: sg.clockStatus()})
+			lastUpdate = time.Now()
+		}
+		sg.mu.Unlock()
+	}
+}
+
+func colorName(c Color) string {
+	switch c {
+	case White:
+		return "white"
+	case Black:
+		return "black"
+	}
+	return ""
+}
+
+// streamDemo starts a server on a local port and plays a whole game through
+// it: two player sockets make the moves while an observer socket prints
+// every event it receives.
+func streamDemo(args []string) {
+	flags := flag.NewFlagSet("stream-demo", flag.ExitOnError)
+	depth := flags.Int("depth", 0, "engine depth for the players, 0 plays random moves")
+	clock := flags.Duration("clock", 5*time.Minute, "initial time per side")
+	increment := flags.Duration("increment", 0, "increment per move")
+	flags.Parse(args)
+
+	rand.Seed(time.Now().UnixNano())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := NewServer(time.Hour, 10*time.Second)
+	go http.Serve(listener, s.Handler())
+	base := "http://" + listener.Addr().String()
+
+	body := fmt.Sprintf(`{"time_ms":%d,"increment_ms":%d}`, clock.Milliseconds(), increment.Milliseconds())
+	resp, err := http.Post(base+"/games", "application/json", strings.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var st createdGame
+	json.NewDecoder(resp.Body).Decode(&st)
+	resp.Body.Close()
+	wsURL := "ws://" + listener.Addr().String() + "/games/" + st.ID + "/ws"
+
+	observer, err := DialWebSocket(wsURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	done := make(chan string)
+	for _, seat := range []string{"white", "black"} {
+		ws, err := DialWebSocket(wsURL + "?seat=" + seat + "&token=" + st.Tokens[seat])
+		if err != nil {
+			log.Fatal(err)
+		}
+		go demoPlayer(ws, *depth)
+	}
+
+	go func() {
+		for {
+			var ev streamEvent
+			if err := observer.ReadJSON(&ev); err != nil {
+				done <- "observer: " + err.Error()
+				return
+			}
+			switch ev.Type {
+			case "move":
+				fmt.Printf("%-6s %-8s %s\n", ev.Move, ev.SAN, ev.Status.FEN)
+			case "clock":
+				fmt.Printf("clock  white %.1fs black %.1fs\n", float64(ev.Clock.White)/1000, float64(ev.Clock.Black)/1000)
+			case "result":
+				done <- ev.Result + " (" + ev.Reason + ")"
+				return
+			}
+		}
+	}()
+	fmt.Println("result:", <-done)
+	observer.Close()
+
+	resp, err = http.Get(base + "/games/" + st.ID + "/pgn")
+	if err != nil {
+		log.Fatal(err)
+	}
+	pgn, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	fmt.Print(string(pgn))
+}
+
+// demoPlayer keeps its own copy of the game from the pushed FEN and answers
+// whenever it is on turn.
+func demoPlayer(ws *WebSocket, depth int) {
+	defer ws.Close()
+	seat := Color(0)
+	for {
+		var ev streamEvent
+		if err := ws.ReadJSON(&ev); err != nil {
+			return
+		}
+		if ev.Type == "state" && ev.Seat != "" {
+			seat = White
+			if ev.Seat == "black" {
+				seat = Black
+			}
+		}
+		if ev.Type == "error" {
+			log.Printf("%s: %s", colorName(seat), ev.Error)
+		}
+		if ev.Status == nil || ev.Status.Result != string(Ongoing) || ev.Status.Turn != colorName(seat) {
+			continue
+		}
+		g, err := ParseFEN(ev.Status.FEN)
+		if err != nil {
+			log.Printf("%s: %s", colorName(seat), err)
+			return
+		}
+		var m *Move
+		if depth > 0 {
+			m = (&Engine{Depth: depth}).Search(g).Move
+		} else {
+			moves := g.possibleMoves()
+			m = moves[rand.Intn(len(moves))]
+		}
+		ws.WriteJSON(streamEvent{Type: "move", Move: m.UCI()})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eventReader reads a socket's events in the background so that tests can
+// wait for them with a timeout.
+func eventReader(ws *WebSocket) chan streamEvent {
+	events := make(chan streamEvent, 64)
+	go func() {
+		defer close(events)
+		for {
+			var ev streamEvent
+			if err := ws.ReadJSON(&ev); err != nil {
+				return
+			}
+			events <- ev
+		}
+	}()
+	return events
+}
+
+// waitFor skips events up to the first of the type.
+func waitFor(t *testing.T, events chan streamEvent, typ string) streamEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				t.Fatalf("stream closed waiting for %q", typ)
+			}
+			if ev.Type == typ {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("no %q event", typ)
+		}
+	}
+}
+
+func TestStreamGame(t *testing.T) {
+	srv := httptest.NewServer(NewServer(time.Hour, time.Second).Handler())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/games", "application/json", strings.NewReader(`{"time_ms":60000}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st createdGame
+	json.NewDecoder(resp.Body).Decode(&st)
+	resp.Body.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/games/" + st.ID + "/ws"
+
+	observer, err := DialWebSocket(wsURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer observer.Close()
+	events := eventReader(observer)
+	waitFor(t, events, "state")
+	players := map[Color]*WebSocket{}
+	for _, seat := range []Color{White, Black} {
+		ws, err := DialWebSocket(wsURL + "?seat=" + colorName(seat) + "&token=" + st.Tokens[colorName(seat)])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+		eventReader(ws)
+		players[seat] = ws
+	}
+
+	// fool's mate, waiting once on Black's clock for an update
+	moves := []string{"f2f3", "e7e5", "g2g4", "d8h4"}
+	sans := []string{"f3", "e5", "g4", "Qh4#"}
+	color := White
+	for i := 0; i < len(moves); i++ {
+		players[color].WriteJSON(streamEvent{Type: "move", Move: moves[i]})
+		ev := waitFor(t, events, "move")
+		if ev.Move != moves[i] || ev.SAN != sans[i] {
+			t.Fatalf("move %d: got %s %s, want %s %s", i, ev.Move, ev.SAN, moves[i], sans[i])
+		}
+		if i == 0 {
+			clock := waitFor(t, events, "clock").Clock
+			if clock.Running != "black" || clock.Black >= 60000 || clock.White != 60000 {
+				t.Fatalf("clock after the first move: %+v", clock)
+			}
+		}
+		color = -color
+	}
+	ev := waitFor(t, events, "result")
+	if ev.Result != string(BlackWins) || ev.Reason != "checkmate" {
+		t.Fatalf("result: got %s (%s)", ev.Result, ev.Reason)
+	}
+}
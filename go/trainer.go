@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+const puzzleStartRating = 1500
+
+// PuzzleStats is the record of one user. Attempts maps puzzle IDs to
+// whether the puzzle was solved.
+type PuzzleStats struct {
+	Rating   float64         `json:"rating"`
+	Solved   int             `json:"solved"`
+	Failed   int             `json:"failed"`
+	Attempts map[string]bool `json:"attempts"`
+}
+
+// Update records an attempt and moves the rating like an Elo game against
+// the puzzle.
+func (s *PuzzleStats) Update(p *Puzzle, solved bool) {
+	score := 0.0
+	if solved {
+		score = 1
+		s.Solved++
+	} else {
+		s.Failed++
+	}
+	s.Rating += 32 * (score - expectedScore(s.Rating-float64(p.Rating)))
+	s.Attempts[p.ID] = solved
+}
+
+func LoadPuzzleStats(path string) (map[string]*PuzzleStats, error) {
+	stats := map[string]*PuzzleStats{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return stats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &stats)
+	return stats, err
+}
+
+func SavePuzzleStats(path string, stats map[string]*PuzzleStats) error {
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// difficultyRange gives the puzzle ratings of a difficulty relative to the
+// user's rating.
+func difficultyRange(difficulty string, rating float64) (float64, float64, error) {
+	switch difficulty {
+	case "":
+		return 0, 10000, nil
+	case "easy":
+		return rating - 600, rating - 200, nil
+	case "normal":
+		return rating - 200, rating + 200, nil
+	case "hard":
+		return rating + 200, rating + 600, nil
+	}
+	return 0, 0, fmt.Errorf("unknown difficulty %q", difficulty)
+}
+
+func hasTheme(p *Puzzle, theme string) bool {
+	for i := 0; i < len(p.Themes); i++ {
+		if strings.EqualFold(p.Themes[i], theme) {
+			return true
+		}
+	}
+	return false
+}
+
+var errQuit = errors.New("quit")
+
+// playPuzzle walks the user through the puzzle and tells whether it was
+// solved. Any mating move is accepted in place of the recorded one.
+func playPuzzle(p *Puzzle, in *bufio.Scanner, out io.Writer) (bool, error) {
+	g, err := ParseFEN(p.FEN)
+	if err != nil {
+		return false, err
+	}
+	solver := g.OnTurn
+	fmt.Fprintf(out, "\npuzzle %s, rating %d\n", p.ID, p.Rating)
+	for i := 0; i < len(p.Moves); i += 2 {
+		expected, err := g.ParseMove(p.Moves[i])
+		if err != nil {
+			return false, fmt.Errorf("puzzle %s: %v", p.ID, err)
+		}
+		g.Board.Fprint(out)
+		for {
+			fmt.Fprintf(out, "%s to move: ", colorName(solver))
+			if !in.Scan() {
+				return false, errQuit
+			}
+			input := strings.TrimSpace(in.Text())
+			switch input {
+			case "quit", "q":
+				return false, errQuit
+			case "hint":
+				fmt.Fprintf(out, "move the piece on %s\n", expected.Start)
+				continue
+			case "skip":
+				fmt.Fprintf(out, "solution: %s\n", strings.Join(p.SAN, " "))
+				return false, nil
+			}
+			m, err := g.ParseMove(input)
+			if err != nil {
+				fmt.Fprintln(out, err)
+				continue
+			}
+			g.doMove(m)
+			if g.isCheckmate() {
+				fmt.Fprintln(out, "checkmate, solved")
+				return true, nil
+			}
+			if m.UCI() != expected.UCI() {
+				fmt.Fprintf(out, "wrong, the solution is %s\n", strings.Join(p.SAN, " "))
+				return false, nil
+			}
+			break
+		}
+		if i+1 < len(p.Moves) {
+			reply, err := g.ParseMove(p.Moves[i+1])
+			if err != nil {
+				return false, fmt.Errorf("puzzle %s: %v", p.ID, err)
+			}
+			fmt.Fprintf(out, "%s plays %s\n", colorName(-solver), g.SAN(reply))
+			g.doMove(reply)
+		}
+	}
+	fmt.Fprintln(out, "solved")
+	return true, nil
+}
+
+func puzzlesCommand(args []string) {
+	flags := flag.NewFlagSet("puzzles", flag.ExitOnError)
+	file := flags.String("file", "puzzles.csv", "puzzle file, CSV or JSON")
+	statsPath := flags.String("stats", "puzzle-stats.json", "file keeping the users' statistics")
+	user := flags.String("user", os.Getenv("USER"), "user name")
+	theme := flags.String("theme", "", "only puzzles with this theme")
+	difficulty := flags.String("difficulty", "", "easy, normal or hard relative to the user's rating")
+	count := flags.Int("n", 10, "puzzles in the session")
+	flags.Parse(args)
+	if *user == "" {
+		*user = "player"
+	}
+
+	puzzles, err := LoadPuzzles(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stats, err := LoadPuzzleStats(*statsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := stats[*user]
+	if s == nil {
+		s = &PuzzleStats{Rating: puzzleStartRating, Attempts: map[string]bool{}}
+		stats[*user] = s
+	}
+	if s.Attempts == nil {
+		s.Attempts = map[string]bool{}
+	}
+	low, high, err := difficultyRange(*difficulty, s.Rating)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	candidates := []*Puzzle{}
+	for i := 0; i < len(puzzles); i++ {
+		p := puzzles[i]
+		if _, done := s.Attempts[p.ID]; done {
+			continue
+		}
+		if *theme != "" && !hasTheme(p, *theme) {
+			continue
+		}
+		if float64(p.Rating) < low || float64(p.Rating) > high {
+			continue
+		}
+		candidates = append(candidates, p)
+	}
+	if len(candidates) == 0 {
+		fmt.Println("no puzzles left for these filters")
+		return
+	}
+	rand.Seed(time.Now().UnixNano())
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	fmt.Printf("%s: rating %.0f, %d solved, %d failed\n", *user, s.Rating, s.Solved, s.Failed)
+	fmt.Println("enter moves in SAN or UCI; hint, skip or quit")
+	in := bufio.NewScanner(os.Stdin)
+	for i := 0; i < len(candidates) && i < *count; i++ {
+		solved, err := playPuzzle(candidates[i], in, os.Stdout)
+		if err == errQuit {
+			break
+		}
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		s.Update(candidates[i], solved)
+		fmt.Printf("rating %.0f\n", s.Rating)
+		if err := SavePuzzleStats(*statsPath, stats); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fmt.Printf("%s: rating %.0f, %d solved, %d failed\n", *user, s.Rating, s.Solved, s.Failed)
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// uci speaks the Universal Chess Interface on stdin/stdout so the engine can
+// be used from GUIs and match runners.
+func uci(args []string) {
+	uciLoop(os.Stdin, os.Stdout)
+}
+
+func uciLoop(in io.Reader, out io.Writer) {
+	game := InitGame()
+	engine := &Engine{}
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "uci":
+			fmt.Fprintln(out, "id name chess")
+			fmt.Fprintln(out, "id author tynovsky")
+			fmt.Fprintln(out, "uciok")
+		case "isready":
+			fmt.Fprintln(out, "readyok")
+		case "ucinewgame":
+			game = InitGame()
+		case "position":
+			g, err := uciPosition(fields[1:])
+			if err != nil {
+				fmt.Fprintln(out, "info string", err)
+				continue
+			}
+			game = g
+		case "go":
+			result := engine.goCommand(game, fields[1:])
+			if result.Move == nil {
+				fmt.Fprintln(out, "bestmove 0000")
+				continue
+			}
+			fmt.Fprintln(out, uciInfo(result))
+			fmt.Fprintln(out, "bestmove", result.Move.UCI())
+		case "quit":
+			return
+		}
+	}
+}
+
+func uciPosition(fields []string) (*Game, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("position: missing arguments")
+	}
+	var g *Game
+	rest := fields[1:]
+	switch fields[0] {
+	case "startpos":
+		g = InitGame()
+	case "fen":
+		n := 0
+		for n < len(rest) && rest[n] != "moves" {
+			n++
+		}
+		parsed, err := ParseFEN(strings.Join(rest[:n], " "))
+		if err != nil {
+			return nil, err
+		}
+		g = parsed
+		rest = rest[n:]
+	default:
+		return nil, fmt.Errorf("position: unknown %q", fields[0])
+	}
+	if len(rest) > 0 && rest[0] == "moves" {
+		for i := 1; i < len(rest); i++ {
+			m, err := g.ParseMove(rest[i])
+			if err != nil {
+				return nil, err
+			}
+			g.doMove(m)
+		}
+	}
+	return g, nil
+}
+
+func (e *Engine) goCommand(g *Game, fields []string) SearchResult {
+	e.Depth = 0
+	e.MoveTime = 0
+	var left, inc time.Duration
+	for i := 0; i+1 < len(fields); i++ {
+		n, err := strconv.Atoi(fields[i+1])
+		if err != nil {
+			continue
+		}
+		ms := time.Duration(n) * time.Millisecond
+		switch {
+		case fields[i] == "depth":
+			e.Depth = n
+		case fields[i] == "movetime":
+			e.MoveTime = ms
+		case fields[i] == "wtime" && g.OnTurn == White, fields[i] == "btime" && g.OnTurn == Black:
+			left = ms
+		case fields[i] == "winc" && g.OnTurn == White, fields[i] == "binc" && g.OnTurn == Black:
+			inc = ms
+		}
+	}
+	if e.MoveTime == 0 && left > 0 {
+		e.MoveTime = allocateTime(left, inc)
+	}
+	return e.Search(g)
+}
+
+func uciInfo(r SearchResult) string {
+	score := fmt.Sprintf("cp %d", r.Score)
+	if r.Score >= MateScore-1000 {
+		score = fmt.Sprintf("mate %d", (MateScore-r.Score+1)/2)
+	} else if r.Score <= -MateScore+1000 {
+		score = fmt.Sprintf("mate -%d", (MateScore+r.Score)/2)
+	}
+	pv := make([]string, len(r.PV))
+	for i := 0; i < len(r.PV); i++ {
+		pv[i] = r.PV[i].UCI()
+	}
+	return fmt.Sprintf("info depth %d score %s nodes %d pv %s", r.Depth, score, r.Nodes, strings.Join(pv, " "))
+}
+
+// uciPlayer drives an external UCI engine running as a subprocess.
+type uciPlayer struct {
+	name     string
+	depth    int
+	moveTime time.Duration
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	lines    chan string
+}
+
+func startUCIPlayer(spec *PlayerSpec) (*uciPlayer, error) {
+	fields := strings.Fields(spec.Cmd)
+	cmd := exec.Command(fields[0], fields[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &uciPlayer{
+		name:     spec.Name,
+		depth:    spec.Depth,
+		moveTime: spec.MoveTime,
+		cmd:      cmd,
+		stdin:    stdin,
+		lines:    make(chan string, 256),
+	}
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			p.lines <- scanner.Text()
+		}
+		close(p.lines)
+	}()
+
+	p.send("uci")
+	for {
+		line, err := p.readLine(10 * time.Second)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		if strings.HasPrefix(line, "id name ") && p.name == "" {
+			p.name = strings.TrimPrefix(line, "id name ")
+		}
+		if line == "uciok" {
+			break
+		}
+	}
+	for name, value := range spec.Options {
+		p.send("setoption name " + name + " value " + value)
+	}
+	if err := p.waitReady(); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *uciPlayer) Name() string {
+	return p.name
+}
+
+func (p *uciPlayer) send(line string) {
+	io.WriteString(p.stdin, line+"\n")
+}
+
+func (p *uciPlayer) readLine(timeout time.Duration) (string, error) {
+	select {
+	case line, ok := <-p.lines:
+		if !ok {
+			return "", errors.New("uci: engine exited")
+		}
+		return line, nil
+	case <-time.After(timeout):
+		return "", errors.New("uci: engine timed out")
+	}
+}
+
+func (p *uciPlayer) waitReady() error {
+	p.send("isready")
+	for {
+		line, err := p.readLine(10 * time.Second)
+		if err != nil {
+			return err
+		}
+		if line == "readyok" {
+			return nil
+		}
+	}
+}
+
+func (p *uciPlayer) NewGame() error {
+	p.send("ucinewgame")
+	return p.waitReady()
+}
+
+func (p *uciPlayer) Think(g *Game, clock *Clock) (*Move, int, error) {
+	moves := make([]string, len(g.Moves))
+	for i := 0; i < len(g.Moves); i++ {
+		moves[i] = g.Moves[i].UCI()
+	}
+	position := "position startpos"
+	if g.StartFEN != "" {
+		position = "position fen " + g.StartFEN
+	}
+	if len(moves) > 0 {
+		position += " moves " + strings.Join(moves, " ")
+	}
+	p.send(position)
+
+	goCmd := "go"
+	timeout := time.Minute
+	if clock != nil {
+		goCmd += fmt.Sprintf(" wtime %d btime %d winc %d binc %d",
+			clock.Remaining(White).Milliseconds(), clock.Remaining(Black).Milliseconds(),
+			clock.Increment.Milliseconds(), clock.Increment.Milliseconds())
+		timeout = clock.Remaining(g.OnTurn) + 5*time.Second
+	}
+	if p.depth > 0 {
+		goCmd += fmt.Sprintf(" depth %d", p.depth)
+	}
+	if p.moveTime > 0 {
+		goCmd += fmt.Sprintf(" movetime %d", p.moveTime.Milliseconds())
+		timeout = p.moveTime + 5*time.Second
+	}
+	p.send(goCmd)
+
+	score := 0
+	for {
+		line, err := p.readLine(timeout)
+		if err != nil {
+			return nil, 0, err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "info" {
+			for i := 1; i+2 < len(fields); i++ {
+				if fields[i] != "score" {
+					continue
+				}
+				n, err := strconv.Atoi(fields[i+2])
+				if err != nil {
+					break
+				}
+				switch fields[i+1] {
+				case "cp":
+					score = n
+				case "mate":
+					if n > 0 {
+						score = MateScore - 2*n + 1
+					} else {
+						score = -MateScore - 2*n
+					}
+				}
+			}
+			continue
+		}
+		if fields[0] == "bestmove" && len(fields) > 1 {
+			m, err := g.ParseMove(fields[1])
+			if err != nil {
+				return nil, 0, fmt.Errorf("uci: %s played %v", p.name, err)
+			}
+			return m, score, nil
+		}
+	}
+}
+
+func (p *uciPlayer) Close() {
+	p.send("quit")
+	done := make(chan struct{})
+	go func() {
+		p.cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		p.cmd.Process.Kill()
+		<-done
+	}
+}
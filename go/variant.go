@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Variant changes how a game is won on top of the usual moves. Rule sets
+// without one end by checkmate, stalemate and the draw rules alone.
+type Variant interface {
+	// Outcome returns the result once the variant's own goal is reached
+	// and Ongoing before.
+	Outcome(g *Game) (Result, string)
+	// Insufficient tells whether neither side can win any more.
+	Insufficient(g *Game) bool
+}
+
+// variantOutcome is the result the rules' variant decides, if any.
+func (g *Game) variantOutcome() (Result, string) {
+	v := g.Board.Rules().Variant
+	if v == nil {
+		return Ongoing, ""
+	}
+	return v.Outcome(g)
+}
+
+// winner is the result of a win for the color.
+func winner(color Color) Result {
+	if color == White {
+		return WhiteWins
+	}
+	return BlackWins
+}
+
+// atomicVariant is lost by the side whose king exploded.
+type atomicVariant struct{}
+
+func (atomicVariant) Outcome(g *Game) (Result, string) {
+	for _, c := range []Color{White, Black} {
+		if g.Board.getKing(c) == nil {
+			return winner(-c), "explosion"
+		}
+	}
+	return Ongoing, ""
+}
+
+func (atomicVariant) Insufficient(g *Game) bool {
+	return g.lacksMatingMaterial()
+}
+
+// threeCheck is won by the side that has given RuleSet.Checks checks.
+type threeCheck struct{}
+
+func (threeCheck) Outcome(g *Game) (Result, string) {
+	limit := g.Board.Rules().Checks
+	for _, c := range []Color{White, Black} {
+		if g.ChecksGiven[colorIndex(c)] >= limit {
+			return winner(c), "checks"
+		}
+	}
+	return Ongoing, ""
+}
+
+// Insufficient holds with bare kings only: any other piece can give check.
+func (threeCheck) Insufficient(g *Game) bool {
+	return len(g.Board.getPieces()) == 2
+}
+
+// kingOfTheHill is won by the side whose king reaches one of the four
+// centre squares.
+type kingOfTheHill struct{}
+
+func (kingOfTheHill) Outcome(g *Game) (Result, string) {
+	b := g.Board
+	for _, c := range []Color{White, Black} {
+		k := b.getKing(c)
+		if k == nil {
+			continue
+		}
+		s := k.Square()
+		if s.x >= b.Files()/2-1 && s.x <= b.Files()/2 && s.y >= b.Ranks()/2-1 && s.y <= b.Ranks()/2 {
+			return winner(c), "king of the hill"
+		}
+	}
+	return Ongoing, ""
+}
+
+// Insufficient never holds since a bare king may still walk to the centre.
+func (kingOfTheHill) Insufficient(g *Game) bool {
+	return false
+}
+
+// racingKings is won by the side whose king reaches the last rank first.
+// White moves first, so Black gets one more move to draw by getting there
+// too.
+type racingKings struct{}
+
+func (racingKings) Outcome(g *Game) (Result, string) {
+	b := g.Board
+	last := b.Ranks() - 1
+	white, black := b.getKing(White), b.getKing(Black)
+	whiteHome := white != nil && white.Square().y == last
+	blackHome := black != nil && black.Square().y == last
+	switch {
+	case whiteHome && blackHome:
+		return Draw, "both kings reached the last rank"
+	case blackHome:
+		return BlackWins, "king reached the last rank"
+	case !whiteHome:
+		return Ongoing, ""
+	}
+	if g.OnTurn == Black {
+		// the game goes on while Black can still catch up
+		moves := b.possibleMoves(Black)
+		for i := 0; i < len(moves); i++ {
+			if _, ok := moves[i].Piece.(*King); ok && moves[i].End.y == last {
+				return Ongoing, ""
+			}
+		}
+	}
+	return WhiteWins, "king reached the last rank"
+}
+
+// Insufficient never holds since a bare king may still win the race.
+func (racingKings) Insufficient(g *Game) bool {
+	return false
+}
+
+// horde is lost by White once the last of its pieces is taken; mate and
+// stalemate go as usual.
+type horde struct{}
+
+func (horde) Outcome(g *Game) (Result, string) {
+	pieces := g.Board.getPieces()
+	for i := 0; i < len(pieces); i++ {
+		if pieces[i].Color() == White {
+			return Ongoing, ""
+		}
+	}
+	return BlackWins, "all pieces lost"
+}
+
+// Insufficient never holds since Black can always hope to take the last
+// white piece.
+func (horde) Insufficient(g *Game) bool {
+	return false
+}
+
+// checksLeft is the three-check FEN field: the checks White and then Black
+// still have to give.
+func (g *Game) checksLeft() string {
+	limit := g.Board.Rules().Checks
+	return fmt.Sprintf("%d+%d", limit-g.ChecksGiven[colorIndex(White)], limit-g.ChecksGiven[colorIndex(Black)])
+}
+
+// setChecksLeft reads the three-check FEN field.
+func (g *Game) setChecksLeft(field string) error {
+	limit := g.Board.Rules().Checks
+	parts := strings.Split(field, "+")
+	if len(parts) != 2 {
+		return fmt.Errorf("fen: bad checks field %q", field)
+	}
+	for i, c := range []Color{White, Black} {
+		left, err := strconv.Atoi(parts[i])
+		if err != nil || left < 0 || left > limit {
+			return fmt.Errorf("fen: bad checks field %q", field)
+		}
+		g.ChecksGiven[colorIndex(c)] = limit - left
+	}
+	return nil
+}
+
+// countCheck adds the check the last move gave to the mover's count in
+// three-check; undo takes it back off.
+func (g *Game) countCheck(undo bool) {
+	if g.Board.Rules().Checks == 0 || !g.Board.inCheck(g.OnTurn) {
+		return
+	}
+	if undo {
+		g.ChecksGiven[colorIndex(-g.OnTurn)]--
+	} else {
+		g.ChecksGiven[colorIndex(-g.OnTurn)]++
+	}
+}
+
+// outcomeScore scores a decided game for the color as the engine does a
+// mate, nearer wins first.
+func outcomeScore(result Result, color Color, ply int) int {
+	switch result {
+	case Draw:
+		return 0
+	case winner(color):
+		return MateScore - ply
+	}
+	return -MateScore + ply
+}